@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package checkpoint persists the CRI metadata frakti is handed for each
+// sandbox and container to disk, so it can be recovered after a crash.
+// hyperd's own labels aren't enough to reconstruct it: fields like
+// Attempt, Annotations and image pull secrets only ever exist in the CRI
+// request that created the sandbox/container.
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+const (
+	sandboxesDir  = "sandboxes"
+	containersDir = "containers"
+)
+
+// ContainerCheckpoint is what gets persisted for a single container: its
+// CRI config plus the sandbox it belongs to, since hyperd has no notion of
+// that relationship on its own.
+type ContainerCheckpoint struct {
+	PodSandboxID string                   `json:"podSandboxId"`
+	Config       *kubeapi.ContainerConfig `json:"config"`
+}
+
+// Store reads and writes sandbox/container checkpoints under rootDir.
+type Store struct {
+	sandboxDir   string
+	containerDir string
+}
+
+// NewStore creates a Store rooted at rootDir, creating the sandbox and
+// container subdirectories if they don't already exist.
+func NewStore(rootDir string) (*Store, error) {
+	s := &Store{
+		sandboxDir:   filepath.Join(rootDir, sandboxesDir),
+		containerDir: filepath.Join(rootDir, containersDir),
+	}
+
+	for _, dir := range []string{s.sandboxDir, s.containerDir} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// WriteSandbox persists config for podSandboxID.
+func (s *Store) WriteSandbox(podSandboxID string, config *kubeapi.PodSandboxConfig) error {
+	return writeJSON(s.sandboxPath(podSandboxID), config)
+}
+
+// ReadSandbox loads the checkpointed config for podSandboxID.
+func (s *Store) ReadSandbox(podSandboxID string) (*kubeapi.PodSandboxConfig, error) {
+	config := &kubeapi.PodSandboxConfig{}
+	if err := readJSON(s.sandboxPath(podSandboxID), config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// RemoveSandbox deletes the checkpoint for podSandboxID, if any.
+func (s *Store) RemoveSandbox(podSandboxID string) error {
+	return removeIfExists(s.sandboxPath(podSandboxID))
+}
+
+// ListSandboxIDs returns the IDs of every sandbox with a checkpoint on
+// disk.
+func (s *Store) ListSandboxIDs() ([]string, error) {
+	return listIDs(s.sandboxDir)
+}
+
+// WriteContainer persists config for containerID, along with the sandbox
+// it belongs to.
+func (s *Store) WriteContainer(containerID, podSandboxID string, config *kubeapi.ContainerConfig) error {
+	return writeJSON(s.containerPath(containerID), &ContainerCheckpoint{
+		PodSandboxID: podSandboxID,
+		Config:       config,
+	})
+}
+
+// ReadContainer loads the checkpointed config for containerID.
+func (s *Store) ReadContainer(containerID string) (*ContainerCheckpoint, error) {
+	checkpoint := &ContainerCheckpoint{}
+	if err := readJSON(s.containerPath(containerID), checkpoint); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// RemoveContainer deletes the checkpoint for containerID, if any.
+func (s *Store) RemoveContainer(containerID string) error {
+	return removeIfExists(s.containerPath(containerID))
+}
+
+// ListContainerIDs returns the IDs of every container with a checkpoint on
+// disk.
+func (s *Store) ListContainerIDs() ([]string, error) {
+	return listIDs(s.containerDir)
+}
+
+func (s *Store) sandboxPath(podSandboxID string) string {
+	return filepath.Join(s.sandboxDir, podSandboxID+".json")
+}
+
+func (s *Store) containerPath(containerID string) string {
+	return filepath.Join(s.containerDir, containerID+".json")
+}
+
+// writeJSON atomically writes v to path: write to a temp file in the same
+// directory, then rename over path, so a crash mid-write never leaves a
+// corrupt checkpoint behind.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func listIDs(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, entry.Name()[:len(entry.Name())-len(".json")])
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,386 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// containerNameSeparator joins the sandbox ID, container name and attempt
+// count into the single string hyperd stores as a container's name, so
+// parseContainerName can recover the CRI metadata later.
+const containerNameSeparator = "_"
+
+// CreateContainer creates a new container in the given pod sandbox.
+func (h *Runtime) CreateContainer(podSandboxID string, config *kubeapi.ContainerConfig, sandboxConfig *kubeapi.PodSandboxConfig) (string, error) {
+	containerSpec, err := buildContainerSpec(podSandboxID, config, sandboxConfig)
+	if err != nil {
+		glog.Errorf("Build container spec for sandbox %q failed: %v", podSandboxID, err)
+		return "", err
+	}
+
+	containerID, err := h.client.CreateContainer(podSandboxID, containerSpec)
+	if err != nil {
+		glog.Errorf("Create container %q in sandbox %q failed: %v", config.GetMetadata().GetName(), podSandboxID, err)
+		return "", err
+	}
+
+	if err := h.checkpoints.WriteContainer(containerID, podSandboxID, config); err != nil {
+		glog.Errorf("Checkpoint container %q failed: %v", containerID, err)
+		return "", err
+	}
+
+	return containerID, nil
+}
+
+// StartContainer starts a previously created container.
+func (h *Runtime) StartContainer(containerID string) error {
+	if err := h.client.StartContainer(containerID); err != nil {
+		glog.Errorf("Start container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// StopContainer stops a running container, giving it timeout seconds to
+// exit gracefully before hyperd kills it.
+func (h *Runtime) StopContainer(containerID string, timeout int64) error {
+	if err := h.client.StopContainer(containerID, timeout); err != nil {
+		glog.Errorf("Stop container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveContainer removes a stopped container.
+func (h *Runtime) RemoveContainer(containerID string) error {
+	if err := h.client.RemoveContainer(containerID); err != nil {
+		glog.Errorf("Remove container %q failed: %v", containerID, err)
+		return err
+	}
+
+	h.statsCache.Remove(containerID)
+
+	if err := h.checkpoints.RemoveContainer(containerID); err != nil {
+		glog.Errorf("Remove checkpoint for container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// ListContainers returns a list of containers matching filter.
+func (h *Runtime) ListContainers(filter *kubeapi.ContainerFilter) ([]*kubeapi.Container, error) {
+	containers, err := h.client.GetContainerList()
+	if err != nil {
+		glog.Errorf("GetContainerList failed: %v", err)
+		return nil, err
+	}
+
+	items := make([]*kubeapi.Container, 0, len(containers))
+	for _, c := range containers {
+		state := toContainerState(c.Status)
+
+		podSandboxID, name, attempt, err := parseContainerName(c.ContainerName)
+		if err != nil {
+			glog.Errorf("ParseContainerName for %s failed: %v", c.ContainerName, err)
+			return nil, err
+		}
+
+		if filter != nil {
+			if filter.Id != nil && c.ContainerID != filter.GetId() {
+				continue
+			}
+			if filter.PodSandboxId != nil && podSandboxID != filter.GetPodSandboxId() {
+				continue
+			}
+			if filter.State != nil && state != filter.GetState() {
+				continue
+			}
+			if filter.LabelSelector != nil && !inMap(filter.LabelSelector, c.Labels) {
+				continue
+			}
+		}
+
+		containerMetadata := &kubeapi.ContainerMetadata{
+			Name:    &name,
+			Attempt: &attempt,
+		}
+
+		createdAtNano := c.CreatedAt * secondToNano
+		items = append(items, &kubeapi.Container{
+			Id:           &c.ContainerID,
+			PodSandboxId: &podSandboxID,
+			Metadata:     containerMetadata,
+			Image:        &kubeapi.ImageSpec{Image: &c.Image},
+			ImageRef:     &c.ImageID,
+			State:        &state,
+			CreatedAt:    &createdAtNano,
+			Labels:       getKubeletLabels(c.Labels),
+			Annotations:  getAnnotationsFromLabels(c.Labels),
+		})
+	}
+
+	return items, nil
+}
+
+// ContainerStatus returns the status of containerID.
+func (h *Runtime) ContainerStatus(containerID string) (*kubeapi.ContainerStatus, error) {
+	info, err := h.client.GetContainerInfo(containerID)
+	if err != nil {
+		glog.Errorf("GetContainerInfo for %s failed: %v", containerID, err)
+		return nil, err
+	}
+
+	state := toContainerState(info.Status.Phase)
+
+	_, name, attempt, err := parseContainerName(info.Container.Name)
+	if err != nil {
+		glog.Errorf("ParseContainerName for %s failed: %v", info.Container.Name, err)
+		return nil, err
+	}
+
+	containerMetadata := &kubeapi.ContainerMetadata{
+		Name:    &name,
+		Attempt: &attempt,
+	}
+
+	createdAtNano := info.CreatedAt * secondToNano
+	status := &kubeapi.ContainerStatus{
+		Id:          &containerID,
+		Metadata:    containerMetadata,
+		State:       &state,
+		CreatedAt:   &createdAtNano,
+		Image:       &kubeapi.ImageSpec{Image: &info.Container.Image},
+		ImageRef:    &info.Container.ImageID,
+		Labels:      getKubeletLabels(info.Container.Labels),
+		Annotations: getAnnotationsFromLabels(info.Container.Labels),
+		Mounts:      toContainerMounts(info.Container.Volumes),
+	}
+
+	switch state {
+	case kubeapi.ContainerState_CONTAINER_RUNNING:
+		status.StartedAt = proto.Int64(info.Status.StartedAt * secondToNano)
+	case kubeapi.ContainerState_CONTAINER_EXITED:
+		exitCode := info.Status.ExitCode
+		status.StartedAt = proto.Int64(info.Status.StartedAt * secondToNano)
+		status.FinishedAt = proto.Int64(info.Status.FinishedAt * secondToNano)
+		status.ExitCode = proto.Int32(exitCode)
+		status.Reason = proto.String(info.Status.Reason)
+	}
+
+	return status, nil
+}
+
+// VolumeMount describes a single mount reported back by hyperd for a
+// container.
+type VolumeMount struct {
+	Source      string
+	Destination string
+	ReadOnly    bool
+}
+
+// toContainerMounts converts hyperd's reported volume mounts into CRI Mount
+// protos.
+func toContainerMounts(volumes []VolumeMount) []*kubeapi.Mount {
+	mounts := make([]*kubeapi.Mount, 0, len(volumes))
+	for _, v := range volumes {
+		volume := v
+		mounts = append(mounts, &kubeapi.Mount{
+			ContainerPath: &volume.Destination,
+			HostPath:      &volume.Source,
+			Readonly:      &volume.ReadOnly,
+		})
+	}
+
+	return mounts
+}
+
+// buildContainerSpec translates a CRI ContainerConfig into the spec hyperd
+// expects, embedding enough of the CRI metadata into the container's name
+// and labels that parseContainerName and getKubeletLabels/
+// getAnnotationsFromLabels can recover it later.
+func buildContainerSpec(podSandboxID string, config *kubeapi.ContainerConfig, sandboxConfig *kubeapi.PodSandboxConfig) (*UserContainer, error) {
+	if config.GetMetadata() == nil {
+		return nil, fmt.Errorf("container config is missing metadata")
+	}
+
+	name := buildContainerName(podSandboxID, config.GetMetadata())
+
+	envs := make(map[string]string, len(config.GetEnvs()))
+	for _, kv := range config.GetEnvs() {
+		envs[kv.GetKey()] = kv.GetValue()
+	}
+
+	return &UserContainer{
+		Name:            name,
+		Image:           config.GetImage().GetImage(),
+		Command:         config.GetCommand(),
+		Args:            config.GetArgs(),
+		Workdir:         config.GetWorkingDir(),
+		Envs:            envs,
+		Labels:          buildLabelsFromKubeletLabels(config.GetLabels(), config.GetAnnotations()),
+		Tty:             config.GetTty(),
+		Volumes:         toVolumeMounts(config.GetMounts()),
+		Resources:       toContainerResources(config.GetLinux().GetResources()),
+		SecurityContext: toContainerSecurityContext(config.GetLinux().GetSecurityContext()),
+	}, nil
+}
+
+// toVolumeMounts translates the CRI mount requests into the VolumeMount
+// shape hyperd's ContainerCreate RPC expects.
+func toVolumeMounts(mounts []*kubeapi.Mount) []VolumeMount {
+	volumes := make([]VolumeMount, 0, len(mounts))
+	for _, m := range mounts {
+		volumes = append(volumes, VolumeMount{
+			Source:      m.GetHostPath(),
+			Destination: m.GetContainerPath(),
+			ReadOnly:    m.GetReadonly(),
+		})
+	}
+
+	return volumes
+}
+
+// toContainerResources translates the CRI Linux resource limits into the
+// shape hyperd's ContainerCreate RPC expects.
+func toContainerResources(resources *kubeapi.LinuxContainerResources) ContainerResources {
+	return ContainerResources{
+		CPUPeriod:          resources.GetCpuPeriod(),
+		CPUQuota:           resources.GetCpuQuota(),
+		CPUShares:          resources.GetCpuShares(),
+		MemoryLimitInBytes: resources.GetMemoryLimitInBytes(),
+	}
+}
+
+// toContainerSecurityContext translates the CRI Linux security context into
+// the shape hyperd's ContainerCreate RPC expects.
+func toContainerSecurityContext(securityContext *kubeapi.LinuxContainerSecurityContext) ContainerSecurityContext {
+	return ContainerSecurityContext{
+		Privileged:     securityContext.GetPrivileged(),
+		ReadonlyRootfs: securityContext.GetReadonlyRootfs(),
+		RunAsUser:      securityContext.GetRunAsUser().GetValue(),
+		AddCapS:        securityContext.GetCapabilities().GetAddCapabilities(),
+		DropCapS:       securityContext.GetCapabilities().GetDropCapabilities(),
+	}
+}
+
+// buildContainerName joins podSandboxID, the CRI container name and attempt
+// count into hyperd's container name, mirroring how sandbox names are built
+// for pods.
+func buildContainerName(podSandboxID string, metadata *kubeapi.ContainerMetadata) string {
+	return strings.Join([]string{
+		podSandboxID,
+		metadata.GetName(),
+		strconv.FormatUint(uint64(metadata.GetAttempt()), 10),
+	}, containerNameSeparator)
+}
+
+// parseContainerName is the inverse of buildContainerName.
+func parseContainerName(containerName string) (podSandboxID, name string, attempt uint32, err error) {
+	parts := strings.Split(containerName, containerNameSeparator)
+	if len(parts) != 3 {
+		return "", "", 0, fmt.Errorf("failed to parse container name %q into <sandboxID>_<name>_<attempt>", containerName)
+	}
+
+	parsedAttempt, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to parse attempt count from container name %q: %v", containerName, err)
+	}
+
+	return parts[0], parts[1], uint32(parsedAttempt), nil
+}
+
+// UserContainer is the container spec hyperd's ContainerCreate RPC expects.
+type UserContainer struct {
+	Name            string
+	Image           string
+	Command         []string
+	Args            []string
+	Workdir         string
+	Envs            map[string]string
+	Labels          map[string]string
+	Tty             bool
+	Volumes         []VolumeMount
+	Resources       ContainerResources
+	SecurityContext ContainerSecurityContext
+}
+
+// ContainerResources mirrors the CPU/memory limits hyperd's ContainerCreate
+// RPC accepts, translated from kubeapi.LinuxContainerResources.
+type ContainerResources struct {
+	CPUPeriod          int64
+	CPUQuota           int64
+	CPUShares          int64
+	MemoryLimitInBytes int64
+}
+
+// ContainerSecurityContext mirrors the subset of
+// kubeapi.LinuxContainerSecurityContext hyperd's ContainerCreate RPC
+// accepts.
+type ContainerSecurityContext struct {
+	Privileged     bool
+	ReadonlyRootfs bool
+	RunAsUser      int64
+	AddCapS        []string
+	DropCapS       []string
+}
+
+const (
+	// kubernetesLabelPrefix namespaces the annotations frakti folds into
+	// hyperd container labels so they round-trip through ContainerStatus.
+	kubernetesLabelPrefix = "io.kubernetes.container."
+)
+
+// buildLabelsFromKubeletLabels merges CRI labels and annotations into the
+// flat label map hyperd stores on the container, namespacing the
+// annotations so getAnnotationsFromLabels/getKubeletLabels can split them
+// back apart.
+func buildLabelsFromKubeletLabels(labels, annotations map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(annotations))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		merged[kubernetesLabelPrefix+k] = v
+	}
+
+	return merged
+}
+
+// toContainerState maps a hyperd container phase to the CRI ContainerState.
+func toContainerState(phase string) kubeapi.ContainerState {
+	switch phase {
+	case "running":
+		return kubeapi.ContainerState_CONTAINER_RUNNING
+	case "pending", "creating":
+		return kubeapi.ContainerState_CONTAINER_CREATED
+	case "succeeded", "failed", "exited":
+		return kubeapi.ContainerState_CONTAINER_EXITED
+	default:
+		return kubeapi.ContainerState_CONTAINER_UNKNOWN
+	}
+}
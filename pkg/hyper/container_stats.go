@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyper
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+
+	"k8s.io/frakti/pkg/hyper/stats"
+)
+
+// ContainerStats returns a snapshot of containerID's resource usage.
+func (h *Runtime) ContainerStats(containerID string) (*kubeapi.ContainerStats, error) {
+	hyperStats, err := h.client.GetContainerStats(containerID)
+	if err != nil {
+		glog.Errorf("GetContainerStats for %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	now := time.Now()
+	h.statsCache.Add(containerID, stats.Sample{
+		Timestamp:        now,
+		CPUUsageNanos:    hyperStats.CPUUsageNanos,
+		MemoryWorkingSet: hyperStats.MemoryWorkingSetBytes,
+	})
+
+	info, err := h.client.GetContainerInfo(containerID)
+	if err != nil {
+		glog.Errorf("GetContainerInfo for %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	_, name, attempt, err := parseContainerName(info.Container.Name)
+	if err != nil {
+		glog.Errorf("ParseContainerName for %s failed: %v", info.Container.Name, err)
+		return nil, err
+	}
+
+	return h.buildContainerStats(containerID, name, attempt, hyperStats, now), nil
+}
+
+// ListContainerStats returns a snapshot of resource usage for every
+// container matching filter.
+func (h *Runtime) ListContainerStats(filter *kubeapi.ContainerStatsFilter) ([]*kubeapi.ContainerStats, error) {
+	containers, err := h.client.GetContainerList()
+	if err != nil {
+		glog.Errorf("GetContainerList failed: %v", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	items := make([]*kubeapi.ContainerStats, 0, len(containers))
+	for _, c := range containers {
+		if filter != nil {
+			if filter.Id != nil && c.ContainerID != filter.GetId() {
+				continue
+			}
+			if filter.PodSandboxId != nil {
+				podSandboxID, _, _, err := parseContainerName(c.ContainerName)
+				if err != nil || podSandboxID != filter.GetPodSandboxId() {
+					continue
+				}
+			}
+		}
+
+		hyperStats, err := h.client.GetContainerStats(c.ContainerID)
+		if err != nil {
+			glog.Warningf("GetContainerStats for %q failed, skipping: %v", c.ContainerID, err)
+			continue
+		}
+		h.statsCache.Add(c.ContainerID, stats.Sample{
+			Timestamp:        now,
+			CPUUsageNanos:    hyperStats.CPUUsageNanos,
+			MemoryWorkingSet: hyperStats.MemoryWorkingSetBytes,
+		})
+
+		_, name, attempt, err := parseContainerName(c.ContainerName)
+		if err != nil {
+			glog.Errorf("ParseContainerName for %s failed: %v", c.ContainerName, err)
+			continue
+		}
+
+		items = append(items, h.buildContainerStats(c.ContainerID, name, attempt, hyperStats, now))
+	}
+
+	return items, nil
+}
+
+// buildContainerStats assembles the CRI ContainerStats proto for
+// containerID from a fresh hyperd sample, filling in the CPU usage rate
+// from the stats cache when at least two samples are available.
+func (h *Runtime) buildContainerStats(containerID, name string, attempt uint32, hyperStats *ContainerResourceStats, now time.Time) *kubeapi.ContainerStats {
+	nowNano := now.UnixNano()
+
+	cpu := &kubeapi.CpuUsage{
+		Timestamp:            &nowNano,
+		UsageCoreNanoSeconds: &kubeapi.UInt64Value{Value: &hyperStats.CPUUsageNanos},
+	}
+	if rate, ok := h.statsCache.CPUNanoCores(containerID); ok {
+		cpu.UsageNanoCores = &kubeapi.UInt64Value{Value: &rate}
+	}
+
+	memory := &kubeapi.MemoryUsage{
+		Timestamp:       &nowNano,
+		WorkingSetBytes: &kubeapi.UInt64Value{Value: &hyperStats.MemoryWorkingSetBytes},
+	}
+
+	stat := &kubeapi.ContainerStats{
+		Attributes: &kubeapi.ContainerAttributes{
+			Id:       &containerID,
+			Metadata: &kubeapi.ContainerMetadata{Name: &name, Attempt: &attempt},
+		},
+		Cpu:    cpu,
+		Memory: memory,
+	}
+
+	if hyperStats.WritableLayerPath != "" {
+		usedBytes := hyperStats.WritableLayerUsageBytes
+		inodesUsed := hyperStats.WritableLayerInodesUsed
+		stat.WritableLayer = &kubeapi.FilesystemUsage{
+			Timestamp:  &nowNano,
+			UsedBytes:  &kubeapi.UInt64Value{Value: &usedBytes},
+			InodesUsed: &kubeapi.UInt64Value{Value: &inodesUsed},
+		}
+	}
+
+	return stat
+}
+
+// ContainerResourceStats is the subset of hyperd's container stats RPC
+// response ContainerStats/ListContainerStats need.
+type ContainerResourceStats struct {
+	CPUUsageNanos           uint64
+	MemoryWorkingSetBytes   uint64
+	WritableLayerPath       string
+	WritableLayerUsageBytes uint64
+	WritableLayerInodesUsed uint64
+}
@@ -17,14 +17,22 @@ limitations under the License.
 package hyper
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 
+	"k8s.io/client-go/tools/remotecommand"
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+
+	"k8s.io/frakti/pkg/hyper/checkpoint"
+	"k8s.io/frakti/pkg/hyper/stats"
+	"k8s.io/frakti/pkg/hyper/streaming"
+	"k8s.io/frakti/pkg/network"
 )
 
 const (
@@ -38,18 +46,69 @@ const (
 
 // Runtime is the HyperContainer implementation of kubelet runtime API
 type Runtime struct {
-	client *Client
+	client          *Client
+	streamingServer streaming.Server
+	networkPlugin   *network.Plugin
+
+	// podIPs caches the IP CNI assigned to each non-hostNetwork sandbox,
+	// since hyperd has no notion of a CNI-managed network namespace to
+	// report it back from.
+	podIPsLock sync.Mutex
+	podIPs     map[string]string
+
+	statsCache *stats.Cache
+
+	checkpoints *checkpoint.Store
 }
 
-// NewHyperRuntime creates a new Runtime
-func NewHyperRuntime(hyperEndpoint string) (*Runtime, error) {
+// NewHyperRuntime creates a new Runtime. streamingServerAddr must be
+// reachable from the kubelet, since it is embedded in the URLs handed back
+// by GetExec/GetAttach/GetPortForward. networkConfig configures the CNI
+// plugin used to network non-hostNetwork sandboxes. rootDir is where CRI
+// metadata is checkpointed so it survives a frakti restart.
+func NewHyperRuntime(hyperEndpoint, streamingServerAddr string, streamingIdleTimeout time.Duration, networkConfig network.Config, rootDir string) (*Runtime, error) {
 	hyperClient, err := NewClient(hyperEndpoint, hyperConnectionTimeout)
 	if err != nil {
 		glog.Fatalf("Initialize hyper client failed: %v", err)
 		return nil, err
 	}
 
-	return &Runtime{client: hyperClient}, nil
+	checkpoints, err := checkpoint.NewStore(rootDir)
+	if err != nil {
+		glog.Errorf("Initialize checkpoint store at %q failed: %v", rootDir, err)
+		return nil, err
+	}
+
+	hyperRuntime := &Runtime{
+		client:        hyperClient,
+		networkPlugin: network.NewPlugin(networkConfig),
+		podIPs:        make(map[string]string),
+		statsCache:    stats.NewCache(0),
+		checkpoints:   checkpoints,
+	}
+
+	if err := hyperRuntime.reconcileCheckpoints(); err != nil {
+		glog.Errorf("Reconcile checkpoints at %q failed: %v", rootDir, err)
+		return nil, err
+	}
+
+	streamingServer, err := streaming.NewServer(streaming.Config{
+		Addr:        streamingServerAddr,
+		IdleTimeout: streamingIdleTimeout,
+	}, hyperRuntime)
+	if err != nil {
+		glog.Errorf("Initialize streaming server failed: %v", err)
+		return nil, err
+	}
+	hyperRuntime.streamingServer = streamingServer
+
+	go func() {
+		if err := streamingServer.Start(); err != nil {
+			glog.Fatalf("Streaming server stopped unexpectedly: %v", err)
+		}
+	}()
+
+	return hyperRuntime, nil
 }
 
 // Version returns the runtime name, runtime version and runtime API version
@@ -69,12 +128,16 @@ func (h *Runtime) Status() (*kubeapi.RuntimeStatus, error) {
 		Type:   proto.String(kubeapi.RuntimeReady),
 		Status: proto.Bool(true),
 	}
-	// Always set networkReady for now.
-	// TODO: get real network status when network plugin is enabled.
 	networkReady := &kubeapi.RuntimeCondition{
 		Type:   proto.String(kubeapi.NetworkReady),
 		Status: proto.Bool(true),
 	}
+	if ready, reason, message := h.networkPlugin.Status(); !ready {
+		networkReady.Status = proto.Bool(false)
+		networkReady.Reason = proto.String(reason)
+		networkReady.Message = proto.String(message)
+	}
+
 	conditions := []*kubeapi.RuntimeCondition{runtimeReady, networkReady}
 	if _, _, err := h.client.GetVersion(); err != nil {
 		runtimeReady.Status = proto.Bool(false)
@@ -108,9 +171,55 @@ func (h *Runtime) RunPodSandbox(config *kubeapi.PodSandboxConfig) (string, error
 		return "", err
 	}
 
+	hasNetwork := !isHostNetwork(config)
+	if hasNetwork {
+		// TODO: the netns SetUpPod creates (network.Plugin.NetNS(podID)) is
+		// never passed to CreatePod/StartPod above, so it's not actually
+		// known whether the hyperd VM's interfaces end up attached to the
+		// network CNI configured here.
+		metadata := config.GetMetadata()
+		podIP, err := h.networkPlugin.SetUpPod(metadata.GetNamespace(), metadata.GetName(), podID, config.GetAnnotations(), config.GetPortMappings())
+		if err != nil {
+			glog.Errorf("Set up pod network for sandbox %q failed: %v", podID, err)
+			if removeError := h.client.RemovePod(podID); removeError != nil {
+				glog.Warningf("Remove pod %q failed: %v", removeError)
+			}
+			return "", err
+		}
+
+		h.podIPsLock.Lock()
+		h.podIPs[podID] = podIP
+		h.podIPsLock.Unlock()
+	}
+
+	if err := h.checkpoints.WriteSandbox(podID, config); err != nil {
+		glog.Errorf("Checkpoint sandbox %q failed: %v", podID, err)
+
+		if hasNetwork {
+			metadata := config.GetMetadata()
+			h.podIPsLock.Lock()
+			delete(h.podIPs, podID)
+			h.podIPsLock.Unlock()
+			if tearDownErr := h.networkPlugin.TearDownPod(metadata.GetNamespace(), metadata.GetName(), podID, config.GetPortMappings()); tearDownErr != nil {
+				glog.Warningf("Tear down pod network for sandbox %q failed: %v", podID, tearDownErr)
+			}
+		}
+		if removeError := h.client.RemovePod(podID); removeError != nil {
+			glog.Warningf("Remove pod %q failed: %v", removeError)
+		}
+
+		return "", err
+	}
+
 	return podID, nil
 }
 
+// isHostNetwork reports whether config opts the sandbox out of CNI
+// networking in favor of sharing the host's network namespace.
+func isHostNetwork(config *kubeapi.PodSandboxConfig) bool {
+	return config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork()
+}
+
 // StopPodSandbox stops the sandbox. If there are any running containers in the
 // sandbox, they should be force terminated.
 func (h *Runtime) StopPodSandbox(podSandboxID string) error {
@@ -126,12 +235,46 @@ func (h *Runtime) StopPodSandbox(podSandboxID string) error {
 // DeletePodSandbox deletes the sandbox. If there are any running containers in the
 // sandbox, they should be force deleted.
 func (h *Runtime) DeletePodSandbox(podSandboxID string) error {
+	h.podIPsLock.Lock()
+	delete(h.podIPs, podSandboxID)
+	h.podIPsLock.Unlock()
+
+	// Whether the sandbox has a network to tear down, and the port
+	// mappings to tear it down with, come from the checkpoint rather than
+	// h.podIPs: h.podIPs is only ever populated by RunPodSandbox, so it is
+	// always empty for a sandbox that existed before a frakti restart, and
+	// keying off it would silently skip CNI teardown for every one of them.
+	checkpointedConfig, checkpointErr := h.checkpoints.ReadSandbox(podSandboxID)
+	if checkpointErr != nil {
+		glog.Warningf("Read checkpoint for sandbox %q failed, assuming it has a network to tear down: %v", podSandboxID, checkpointErr)
+	}
+
+	if checkpointErr != nil || !isHostNetwork(checkpointedConfig) {
+		if info, infoErr := h.client.GetPodInfo(podSandboxID); infoErr == nil {
+			if podName, podNamespace, _, _, nameErr := parseSandboxName(info.PodName); nameErr == nil {
+				var portMappings []*kubeapi.PortMapping
+				if checkpointedConfig != nil {
+					portMappings = checkpointedConfig.GetPortMappings()
+				}
+
+				if err := h.networkPlugin.TearDownPod(podNamespace, podName, podSandboxID, portMappings); err != nil {
+					glog.Errorf("Tear down pod network for sandbox %q failed: %v", podSandboxID, err)
+				}
+			}
+		}
+	}
+
 	err := h.client.RemovePod(podSandboxID)
 	if err != nil {
 		glog.Errorf("Remove pod %s failed: %v", podSandboxID, err)
 		return err
 	}
 
+	if err := h.checkpoints.RemoveSandbox(podSandboxID); err != nil {
+		glog.Errorf("Remove checkpoint for sandbox %q failed: %v", podSandboxID, err)
+		return err
+	}
+
 	return nil
 }
 
@@ -149,6 +292,13 @@ func (h *Runtime) PodSandboxStatus(podSandboxID string) (*kubeapi.PodSandboxStat
 		podIP = info.Status.PodIP[0]
 	}
 
+	h.podIPsLock.Lock()
+	cniIP, ok := h.podIPs[podSandboxID]
+	h.podIPsLock.Unlock()
+	if ok {
+		podIP = cniIP
+	}
+
 	podName, podNamespace, podUID, attempt, err := parseSandboxName(info.PodName)
 	if err != nil {
 		glog.Errorf("ParseSandboxName for %s failed: %v", info.PodName, err)
@@ -232,27 +382,139 @@ func (h *Runtime) ListPodSandbox(filter *kubeapi.PodSandboxFilter) ([]*kubeapi.P
 	return items, nil
 }
 
-// ExecSync runs a command in a container synchronously.
-func (h *Runtime) ExecSync() error {
-	return fmt.Errorf("Not implemented")
+// ExecSync runs a command in a container synchronously and returns its
+// stdout, stderr and exit code, failing if it does not complete within
+// timeout. A timeout of 0 means no deadline.
+func (h *Runtime) ExecSync(containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error) {
+	execID, err := h.client.ExecCmd(containerID, cmd, false)
+	if err != nil {
+		glog.Errorf("ExecCmd in container %q failed: %v", containerID, err)
+		return nil, nil, -1, err
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- h.client.ExecVM(execID, nil, nopWriteCloser{&stdoutBuf}, nopWriteCloser{&stderrBuf}, false)
+	}()
+
+	if timeout > 0 {
+		select {
+		case execErr := <-done:
+			err = execErr
+		case <-time.After(timeout):
+			return nil, nil, 0, fmt.Errorf("exec %q in container %q timed out after %v", execID, containerID, timeout)
+		}
+	} else {
+		err = <-done
+	}
+
+	if err != nil {
+		glog.Errorf("ExecSync for container %q failed: %v", containerID, err)
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), -1, err
+	}
+
+	code, err := h.client.GetExitCode(containerID, execID)
+	if err != nil {
+		glog.Errorf("GetExitCode for container %q exec %q failed: %v", containerID, execID, err)
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), -1, err
+	}
+
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), code, nil
+}
+
+// Exec executes cmd in containerID, wiring stdin/stdout/stderr to hyperd's
+// ExecVM RPC. If resize is non-nil, terminal resize events are forwarded via
+// hyperd's WinResize RPC for the lifetime of the exec session.
+func (h *Runtime) Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	execID, err := h.client.ExecCmd(containerID, cmd, tty)
+	if err != nil {
+		glog.Errorf("ExecCmd in container %q failed: %v", containerID, err)
+		return err
+	}
+
+	if resize != nil {
+		go h.handleResize(containerID, execID, resize)
+	}
+
+	if err := h.client.ExecVM(execID, stdin, stdout, stderr, tty); err != nil {
+		glog.Errorf("ExecVM %q in container %q failed: %v", execID, containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// Attach attaches to the standard streams of a running container, forwarding
+// terminal resize events the same way Exec does.
+func (h *Runtime) Attach(containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	if resize != nil {
+		go h.handleResize(containerID, "", resize)
+	}
+
+	if err := h.client.Attach(containerID, stdin, stdout, stderr, tty); err != nil {
+		glog.Errorf("Attach to container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// PortForward copies data between stream and port inside podSandboxID's
+// network namespace.
+func (h *Runtime) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	if err := h.client.PortForward(podSandboxID, port, stream); err != nil {
+		glog.Errorf("PortForward to sandbox %q port %d failed: %v", podSandboxID, port, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetExec returns the single-use URL the kubelet should dial to redeem an
+// exec stream.
+func (h *Runtime) GetExec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
+	return h.streamingServer.GetExec(req)
 }
 
-// Exec execute a command in the container.
-func (h *Runtime) Exec(rawContainerID string, cmd []string, tty bool, stdin io.Reader, stdout, stderr io.WriteCloser) error {
-	return fmt.Errorf("Not implemented")
+// GetAttach returns the single-use URL the kubelet should dial to redeem an
+// attach stream.
+func (h *Runtime) GetAttach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
+	return h.streamingServer.GetAttach(req)
 }
 
-// Attach prepares a streaming endpoint to attach to a running container.
-func (h *Runtime) Attach() error {
-	return fmt.Errorf("Not implemented")
+// GetPortForward returns the single-use URL the kubelet should dial to
+// redeem a port-forward stream.
+func (h *Runtime) GetPortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
+	return h.streamingServer.GetPortForward(req)
 }
 
-// PortForward prepares a streaming endpoint to forward ports from a PodSandbox.
-func (h *Runtime) PortForward() error {
-	return fmt.Errorf("Not implemented")
+// handleResize forwards terminal resize events to hyperd's WinResize RPC
+// until resize is closed.
+func (h *Runtime) handleResize(containerID, execID string, resize <-chan remotecommand.TerminalSize) {
+	for size := range resize {
+		if err := h.client.WinResize(containerID, execID, size.Width, size.Height); err != nil {
+			glog.Errorf("WinResize container %q exec %q failed: %v", containerID, execID, err)
+		}
+	}
 }
 
-// UpdateRuntimeConfig updates runtime configuration if specified
+// UpdateRuntimeConfig updates runtime configuration if specified. Today
+// this only logs the PodCIDR the kubelet has assigned the node; the bridge
+// plugin's own CNI config on disk is the source of truth for how pods are
+// actually addressed.
 func (h *Runtime) UpdateRuntimeConfig(runtimeConfig *kubeapi.RuntimeConfig) error {
+	if podCIDR := runtimeConfig.GetNetworkConfig().GetPodCidr(); podCIDR != "" {
+		glog.Infof("UpdateRuntimeConfig: PodCIDR is %s", podCIDR)
+	}
+
 	return nil
 }
+
+// nopWriteCloser adapts an io.Writer (such as a bytes.Buffer) to
+// io.WriteCloser for callers that only care about the buffered bytes.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,192 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// ImageService is the HyperContainer implementation of kubelet's
+// ImageManagerService. It is kept separate from Runtime, mirroring how the
+// CRI itself splits the runtime and image services, but shares the same
+// hyperd client and gRPC server.
+type ImageService struct {
+	client *Client
+	// defaultRegistry is prepended to image refs that don't already
+	// specify a registry, so bare names like "busybox" resolve the same
+	// way they do for other runtimes.
+	defaultRegistry string
+}
+
+// NewImageService creates a new ImageService sharing hyperEndpoint with a
+// Runtime. defaultRegistry is used to resolve unqualified image refs.
+func NewImageService(hyperEndpoint, defaultRegistry string) (*ImageService, error) {
+	hyperClient, err := NewClient(hyperEndpoint, hyperConnectionTimeout)
+	if err != nil {
+		glog.Errorf("Initialize hyper client failed: %v", err)
+		return nil, err
+	}
+
+	return &ImageService{client: hyperClient, defaultRegistry: defaultRegistry}, nil
+}
+
+// ListImages lists the images matching filter known to hyperd.
+func (i *ImageService) ListImages(filter *kubeapi.ImageFilter) ([]*kubeapi.Image, error) {
+	images, err := i.client.GetImageList()
+	if err != nil {
+		glog.Errorf("GetImageList failed: %v", err)
+		return nil, err
+	}
+
+	var refFilter string
+	if filter != nil && filter.GetImage() != nil {
+		refFilter = i.resolveImageRef(filter.GetImage().GetImage())
+	}
+
+	items := make([]*kubeapi.Image, 0, len(images))
+	for _, img := range images {
+		if refFilter != "" && !inStrings(refFilter, img.RepoTags) && !inStrings(refFilter, img.RepoDigests) {
+			continue
+		}
+
+		size := uint64(img.VirtualSize)
+		items = append(items, &kubeapi.Image{
+			Id:          &img.Id,
+			RepoTags:    img.RepoTags,
+			RepoDigests: img.RepoDigests,
+			Size_:       &size,
+		})
+	}
+
+	return items, nil
+}
+
+// ImageStatus returns the status of the image referenced by image, or nil
+// if it is not present.
+func (i *ImageService) ImageStatus(image *kubeapi.ImageSpec) (*kubeapi.Image, error) {
+	ref := i.resolveImageRef(image.GetImage())
+
+	info, err := i.client.GetImageInfo(ref, "")
+	if err != nil {
+		glog.Errorf("GetImageInfo for %q failed: %v", ref, err)
+		return nil, err
+	}
+	if info == nil {
+		return nil, nil
+	}
+
+	size := uint64(info.VirtualSize)
+	return &kubeapi.Image{
+		Id:          &info.Id,
+		RepoTags:    info.RepoTags,
+		RepoDigests: info.RepoDigests,
+		Size_:       &size,
+	}, nil
+}
+
+// PullImage pulls image from its registry, authenticating with auth if
+// provided.
+func (i *ImageService) PullImage(image *kubeapi.ImageSpec, auth *kubeapi.AuthConfig) (string, error) {
+	ref := i.resolveImageRef(image.GetImage())
+
+	var authConfig *ImageAuthConfig
+	if auth != nil {
+		authConfig = &ImageAuthConfig{
+			Username:      auth.GetUsername(),
+			Password:      auth.GetPassword(),
+			Auth:          auth.GetAuth(),
+			ServerAddress: auth.GetServerAddress(),
+			IdentityToken: auth.GetIdentityToken(),
+		}
+	}
+
+	if err := i.client.PullImage(ref, authConfig); err != nil {
+		glog.Errorf("Pull image %q failed: %v", ref, err)
+		return "", err
+	}
+
+	return ref, nil
+}
+
+// RemoveImage removes image from hyperd's local image store.
+func (i *ImageService) RemoveImage(image *kubeapi.ImageSpec) error {
+	ref := i.resolveImageRef(image.GetImage())
+
+	if err := i.client.RemoveImage(ref); err != nil {
+		glog.Errorf("Remove image %q failed: %v", ref, err)
+		return err
+	}
+
+	return nil
+}
+
+// resolveImageRef qualifies ref with defaultRegistry when it doesn't
+// already name one, matching Docker's own heuristic: a ref is considered
+// already qualified if its first path segment looks like a hostname (it
+// contains a '.' or ':', or is literally "localhost").
+func (i *ImageService) resolveImageRef(ref string) string {
+	if ref == "" || i.defaultRegistry == "" {
+		return ref
+	}
+
+	if isRegistryQualified(ref) || strings.HasPrefix(ref, i.defaultRegistry+"/") {
+		return ref
+	}
+
+	return fmt.Sprintf("%s/%s", i.defaultRegistry, ref)
+}
+
+// isRegistryQualified reports whether ref's first path segment names a
+// registry host rather than the first component of an image name. A ref
+// with no '/' at all, like "busybox:1.31", has no registry segment to
+// inspect: the colon there is a tag separator, not a port.
+func isRegistryQualified(ref string) bool {
+	i := strings.IndexRune(ref, '/')
+	if i == -1 {
+		return false
+	}
+
+	host := ref[:i]
+
+	return strings.ContainsAny(host, ".:") || host == "localhost"
+}
+
+// ImageAuthConfig carries registry credentials through to hyperd's pull
+// RPC, mirroring kubeapi.AuthConfig.
+type ImageAuthConfig struct {
+	Username      string
+	Password      string
+	Auth          string
+	ServerAddress string
+	IdentityToken string
+}
+
+// inStrings reports whether needle is present in haystack.
+func inStrings(needle string, haystack []string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
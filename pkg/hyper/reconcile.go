@@ -0,0 +1,102 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyper
+
+import (
+	"github.com/golang/glog"
+)
+
+// reconcileCheckpoints runs once at startup to bring the on-disk checkpoint
+// state back in line with what hyperd actually has running: checkpoints
+// whose sandbox or container no longer exists in hyperd are garbage
+// collected, and hyperd pods that carry kubelet ownership labels but have
+// no checkpoint are logged as adopted, best-effort, since their original
+// CRI metadata (Attempt, Annotations, pull secrets) cannot be recovered
+// from hyperd labels alone.
+func (h *Runtime) reconcileCheckpoints() error {
+	pods, err := h.client.GetPodList()
+	if err != nil {
+		glog.Errorf("GetPodList during checkpoint reconciliation failed: %v", err)
+		return err
+	}
+	livePods := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		livePods[pod.PodID] = true
+		if len(getKubeletLabels(pod.Labels)) == 0 {
+			continue
+		}
+
+		config, err := h.checkpoints.ReadSandbox(pod.PodID)
+		if err != nil {
+			glog.Warningf("Sandbox %q is owned by the kubelet but has no checkpoint; adopting without recovered CRI metadata", pod.PodID)
+			continue
+		}
+		glog.Infof("Adopted sandbox %q from its checkpoint: attempt %d, %d port mapping(s)", pod.PodID, config.GetMetadata().GetAttempt(), len(config.GetPortMappings()))
+	}
+
+	checkpointedSandboxes, err := h.checkpoints.ListSandboxIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed sandboxes failed: %v", err)
+		return err
+	}
+	for _, podSandboxID := range checkpointedSandboxes {
+		if livePods[podSandboxID] {
+			continue
+		}
+		glog.Infof("Garbage collecting checkpoint for sandbox %q, no longer present in hyperd", podSandboxID)
+		if err := h.checkpoints.RemoveSandbox(podSandboxID); err != nil {
+			glog.Errorf("Garbage collect checkpoint for sandbox %q failed: %v", podSandboxID, err)
+		}
+	}
+
+	containers, err := h.client.GetContainerList()
+	if err != nil {
+		glog.Errorf("GetContainerList during checkpoint reconciliation failed: %v", err)
+		return err
+	}
+	liveContainers := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		liveContainers[c.ContainerID] = true
+		if len(getKubeletLabels(c.Labels)) == 0 {
+			continue
+		}
+
+		checkpoint, err := h.checkpoints.ReadContainer(c.ContainerID)
+		if err != nil {
+			glog.Warningf("Container %q is owned by the kubelet but has no checkpoint; adopting without recovered CRI metadata", c.ContainerID)
+			continue
+		}
+		glog.Infof("Adopted container %q from its checkpoint: sandbox %q, %d mount(s)", c.ContainerID, checkpoint.PodSandboxID, len(checkpoint.Config.GetMounts()))
+	}
+
+	checkpointedContainers, err := h.checkpoints.ListContainerIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed containers failed: %v", err)
+		return err
+	}
+	for _, containerID := range checkpointedContainers {
+		if liveContainers[containerID] {
+			continue
+		}
+		glog.Infof("Garbage collecting checkpoint for container %q, no longer present in hyperd", containerID)
+		if err := h.checkpoints.RemoveContainer(containerID); err != nil {
+			glog.Errorf("Garbage collect checkpoint for container %q failed: %v", containerID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stats keeps a short rolling window of per-container CPU samples
+// so ContainerStats can report a CPU usage rate, which hyperd's stats RPC
+// only gives us as a cumulative counter.
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultWindow bounds how long a sample is kept before it is evicted, and
+// therefore the coarsest rate ContainerStats can compute.
+const defaultWindow = 30 * time.Second
+
+// Sample is one cumulative CPU usage reading for a container.
+type Sample struct {
+	Timestamp        time.Time
+	CPUUsageNanos    uint64
+	MemoryWorkingSet uint64
+}
+
+// Cache remembers every sample per container that falls within its window
+// so a CPU usage rate can be derived from two cumulative readings. It is safe for
+// concurrent use, since the kubelet can call ContainerStats,
+// ListContainerStats and RemoveContainer against the same container at the
+// same time.
+type Cache struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]Sample
+}
+
+// NewCache creates a Cache that evicts samples older than window. A zero
+// window uses the default of 30s.
+func NewCache(window time.Duration) *Cache {
+	if window <= 0 {
+		window = defaultWindow
+	}
+
+	return &Cache{
+		window:  window,
+		samples: make(map[string][]Sample),
+	}
+}
+
+// Add records a new sample for containerID, evicting anything older than
+// the cache's window.
+func (c *Cache) Add(containerID string, sample Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := sample.Timestamp.Add(-c.window)
+
+	kept := c.samples[containerID][:0]
+	for _, s := range c.samples[containerID] {
+		if s.Timestamp.After(cutoff) {
+			kept = append(kept, s)
+		}
+	}
+
+	c.samples[containerID] = append(kept, sample)
+}
+
+// CPUNanoCores returns the average CPU usage rate, in nanocores, between
+// the oldest and newest samples currently cached for containerID. The
+// second return value is false if there are fewer than two samples.
+func (c *Cache) CPUNanoCores(containerID string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := c.samples[containerID]
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.Timestamp.Sub(first.Timestamp)
+	if elapsed <= 0 || last.CPUUsageNanos < first.CPUUsageNanos {
+		return 0, false
+	}
+
+	return uint64(float64(last.CPUUsageNanos-first.CPUUsageNanos) / elapsed.Seconds()), true
+}
+
+// Latest returns the most recent sample cached for containerID.
+func (c *Cache) Latest(containerID string) (Sample, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := c.samples[containerID]
+	if len(samples) == 0 {
+		return Sample{}, false
+	}
+
+	return samples[len(samples)-1], true
+}
+
+// Remove forgets every sample cached for containerID, e.g. once it's been
+// removed.
+func (c *Cache) Remove(containerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.samples, containerID)
+}
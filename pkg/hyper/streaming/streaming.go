@@ -0,0 +1,145 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package streaming stands up the CRI streaming endpoints (exec/attach/
+// port-forward) on top of hyperd. It is the frakti analogue of the
+// streaming server dockershim and CRI-O embed: the kubelet obtains a
+// single-use URL from Server.GetExec/GetAttach/GetPortForward, then
+// connects to that URL, and the bytes are moved by Runtime.Exec/Attach/
+// PortForward.
+package streaming
+
+import (
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/remotecommand"
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+	k8sstreaming "k8s.io/kubernetes/pkg/kubelet/server/streaming"
+)
+
+const (
+	// defaultStreamIdleTimeout is how long a redeemed token may sit idle
+	// before the connection is torn down.
+	defaultStreamIdleTimeout = 4 * time.Hour
+	// defaultStreamCreationTimeout is the upstream streaming server's
+	// default StreamCreationTimeout. NewServer does not read this constant;
+	// it keeps the same value by starting from k8sstreaming.DefaultConfig.
+	defaultStreamCreationTimeout = k8sstreaming.DefaultConfig.StreamCreationTimeout
+)
+
+// Runtime is the subset of hyper.Runtime that the streaming server needs
+// in order to move bytes once a client redeems a token. hyper.Runtime
+// satisfies this interface directly.
+type Runtime interface {
+	// Exec executes a command in a container.
+	Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+	// Attach attaches to the standard streams of a running container.
+	Attach(containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+	// PortForward copies data between a client stream and a port inside
+	// the pod sandbox's network namespace.
+	PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error
+}
+
+// Server serves the exec/attach/port-forward streaming endpoints and hands
+// out the single-use URLs returned to the kubelet.
+type Server interface {
+	// Start starts the HTTP server listening for stream redemptions.
+	Start() error
+	// Stop stops the HTTP server.
+	Stop() error
+
+	GetExec(*kubeapi.ExecRequest) (*kubeapi.ExecResponse, error)
+	GetAttach(*kubeapi.AttachRequest) (*kubeapi.AttachResponse, error)
+	GetPortForward(*kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error)
+}
+
+// Config holds the configuration needed to stand up the streaming server.
+type Config struct {
+	// Addr is the host:port the streaming server listens on. It must be
+	// reachable from the kubelet, since the URLs handed back to GetExec/
+	// GetAttach/GetPortForward point at it.
+	Addr string
+	// IdleTimeout is how long a redeemed token may sit idle before the
+	// connection is torn down. Defaults to defaultStreamIdleTimeout.
+	IdleTimeout time.Duration
+}
+
+type server struct {
+	config Config
+	inner  k8sstreaming.Server
+}
+
+// NewServer builds a Server that multiplexes exec/attach/port-forward
+// requests over SPDY/WebSocket (per the v4.channel.k8s.io remote-command
+// protocol) and dispatches the bytes to runtime.
+func NewServer(config Config, runtime Runtime) (Server, error) {
+	idleTimeout := config.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultStreamIdleTimeout
+	}
+
+	streamingConfig := k8sstreaming.DefaultConfig
+	streamingConfig.Addr = config.Addr
+	streamingConfig.StreamIdleTimeout = idleTimeout
+
+	inner, err := k8sstreaming.NewServer(streamingConfig, &execHandler{runtime: runtime})
+	if err != nil {
+		return nil, err
+	}
+
+	return &server{config: config, inner: inner}, nil
+}
+
+func (s *server) Start() error {
+	glog.Infof("Starting streaming server on %s", s.config.Addr)
+	return s.inner.Start(true)
+}
+
+func (s *server) Stop() error {
+	return s.inner.Stop()
+}
+
+func (s *server) GetExec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
+	return s.inner.GetExec(req)
+}
+
+func (s *server) GetAttach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
+	return s.inner.GetAttach(req)
+}
+
+func (s *server) GetPortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
+	return s.inner.GetPortForward(req)
+}
+
+// execHandler adapts Runtime to the k8s.io/kubernetes/pkg/kubelet/server/streaming.Runtime
+// interface that the embedded streaming server drives stream redemption through.
+type execHandler struct {
+	runtime Runtime
+}
+
+func (e *execHandler) Exec(containerID string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return e.runtime.Exec(containerID, cmd, in, out, errOut, tty, resize)
+}
+
+func (e *execHandler) Attach(containerID string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return e.runtime.Attach(containerID, in, out, errOut, tty, resize)
+}
+
+func (e *execHandler) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	return e.runtime.PortForward(podSandboxID, port, stream)
+}
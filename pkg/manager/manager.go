@@ -0,0 +1,501 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manager routes CRI calls to one of several runtime backends,
+// RuntimeClass-style: VM-isolated pods go to hyperd, pods that opt out of
+// VM isolation go to a shared-kernel runc backend. It remembers which
+// backend owns each sandbox/container so later calls against the same ID
+// reach the right one.
+package manager
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	"k8s.io/client-go/tools/remotecommand"
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+const (
+	// osContainerAnnotation opts a pod out of VM isolation and onto the
+	// shared-kernel runc backend.
+	osContainerAnnotation = "runtime.frakti.alpha.kubernetes.io/OSContainer"
+
+	backendHyper = "hyper"
+	backendRunc  = "runc"
+)
+
+// backendNames orders backends for iteration where the order matters, e.g.
+// merging Status conditions deterministically.
+var backendNames = []string{backendHyper, backendRunc}
+
+// Backend is the subset of the CRI runtime service that every backend must
+// implement so RuntimeManager can dispatch to it uniformly.
+type Backend interface {
+	Version() (string, string, string, error)
+	Status() (*kubeapi.RuntimeStatus, error)
+
+	RunPodSandbox(config *kubeapi.PodSandboxConfig) (string, error)
+	StopPodSandbox(podSandboxID string) error
+	DeletePodSandbox(podSandboxID string) error
+	PodSandboxStatus(podSandboxID string) (*kubeapi.PodSandboxStatus, error)
+	ListPodSandbox(filter *kubeapi.PodSandboxFilter) ([]*kubeapi.PodSandbox, error)
+
+	CreateContainer(podSandboxID string, config *kubeapi.ContainerConfig, sandboxConfig *kubeapi.PodSandboxConfig) (string, error)
+	StartContainer(containerID string) error
+	StopContainer(containerID string, timeout int64) error
+	RemoveContainer(containerID string) error
+	ListContainers(filter *kubeapi.ContainerFilter) ([]*kubeapi.Container, error)
+	ContainerStatus(containerID string) (*kubeapi.ContainerStatus, error)
+
+	ExecSync(containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error)
+	Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+	Attach(containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+	PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error
+	GetExec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error)
+	GetAttach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error)
+	GetPortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error)
+
+	ContainerStats(containerID string) (*kubeapi.ContainerStats, error)
+	ListContainerStats(filter *kubeapi.ContainerStatsFilter) ([]*kubeapi.ContainerStats, error)
+
+	UpdateRuntimeConfig(runtimeConfig *kubeapi.RuntimeConfig) error
+}
+
+// RuntimeManager implements the CRI runtime service by dispatching every
+// call to whichever Backend owns the sandbox or container in question.
+type RuntimeManager struct {
+	backends map[string]Backend
+
+	// owners is persisted to ownerStore so backend ownership survives a
+	// frakti restart.
+	mu     sync.Mutex
+	owners map[string]string
+	store  *ownerStore
+}
+
+// NewRuntimeManager creates a RuntimeManager dispatching between hyper and
+// runc, restoring any ownership recorded at stateDir from a previous run.
+func NewRuntimeManager(hyperBackend, runcBackend Backend, stateDir string) (*RuntimeManager, error) {
+	store, err := newOwnerStore(stateDir)
+	if err != nil {
+		glog.Errorf("Load backend ownership state from %q failed: %v", stateDir, err)
+		return nil, err
+	}
+
+	owners, err := store.Load()
+	if err != nil {
+		glog.Errorf("Read backend ownership state from %q failed: %v", stateDir, err)
+		return nil, err
+	}
+
+	return &RuntimeManager{
+		backends: map[string]Backend{
+			backendHyper: hyperBackend,
+			backendRunc:  runcBackend,
+		},
+		owners: owners,
+		store:  store,
+	}, nil
+}
+
+// Version reports the hyper backend's version, since that is the primary
+// backend frakti exposes to the kubelet.
+func (m *RuntimeManager) Version() (string, string, string, error) {
+	return m.backends[backendHyper].Version()
+}
+
+// Status merges the readiness of every backend: frakti is only ready if
+// all backends are.
+func (m *RuntimeManager) Status() (*kubeapi.RuntimeStatus, error) {
+	var order []string
+	merged := make(map[string]*kubeapi.RuntimeCondition)
+
+	for _, name := range backendNames {
+		status, err := m.backends[name].Status()
+		if err != nil {
+			glog.Errorf("Status on %s backend failed: %v", name, err)
+			return nil, err
+		}
+
+		for _, condition := range status.GetConditions() {
+			conditionType := condition.GetType()
+			existing, ok := merged[conditionType]
+			if !ok {
+				order = append(order, conditionType)
+				merged[conditionType] = condition
+				continue
+			}
+
+			if !condition.GetStatus() && existing.GetStatus() {
+				merged[conditionType] = &kubeapi.RuntimeCondition{
+					Type:    proto.String(conditionType),
+					Status:  proto.Bool(false),
+					Reason:  proto.String(fmt.Sprintf("%s: %s", name, condition.GetReason())),
+					Message: proto.String(condition.GetMessage()),
+				}
+			}
+		}
+	}
+
+	conditions := make([]*kubeapi.RuntimeCondition, 0, len(order))
+	for _, conditionType := range order {
+		conditions = append(conditions, merged[conditionType])
+	}
+
+	return &kubeapi.RuntimeStatus{Conditions: conditions}, nil
+}
+
+// RunPodSandbox picks a backend based on config's annotations, creates the
+// sandbox there, and remembers the choice for later calls.
+func (m *RuntimeManager) RunPodSandbox(config *kubeapi.PodSandboxConfig) (string, error) {
+	backendName := backendFor(config.GetAnnotations())
+
+	podSandboxID, err := m.backends[backendName].RunPodSandbox(config)
+	if err != nil {
+		glog.Errorf("RunPodSandbox on %s backend failed: %v", backendName, err)
+		return "", err
+	}
+
+	if err := m.setOwner(podSandboxID, backendName); err != nil {
+		glog.Errorf("Record backend ownership for sandbox %q failed: %v", podSandboxID, err)
+		return "", err
+	}
+
+	return podSandboxID, nil
+}
+
+// StopPodSandbox dispatches to whichever backend owns podSandboxID.
+func (m *RuntimeManager) StopPodSandbox(podSandboxID string) error {
+	backend, err := m.backendOf(podSandboxID)
+	if err != nil {
+		return err
+	}
+
+	return backend.StopPodSandbox(podSandboxID)
+}
+
+// DeletePodSandbox dispatches to whichever backend owns podSandboxID, then
+// forgets the ownership record.
+func (m *RuntimeManager) DeletePodSandbox(podSandboxID string) error {
+	backend, err := m.backendOf(podSandboxID)
+	if err != nil {
+		return err
+	}
+
+	if err := backend.DeletePodSandbox(podSandboxID); err != nil {
+		return err
+	}
+
+	return m.clearOwner(podSandboxID)
+}
+
+// PodSandboxStatus dispatches to whichever backend owns podSandboxID.
+func (m *RuntimeManager) PodSandboxStatus(podSandboxID string) (*kubeapi.PodSandboxStatus, error) {
+	backend, err := m.backendOf(podSandboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.PodSandboxStatus(podSandboxID)
+}
+
+// ListPodSandbox merges the results of every backend, de-duplicating by ID.
+func (m *RuntimeManager) ListPodSandbox(filter *kubeapi.PodSandboxFilter) ([]*kubeapi.PodSandbox, error) {
+	seen := make(map[string]bool)
+	var merged []*kubeapi.PodSandbox
+
+	for name, backend := range m.backends {
+		pods, err := backend.ListPodSandbox(filter)
+		if err != nil {
+			glog.Errorf("ListPodSandbox on %s backend failed: %v", name, err)
+			return nil, err
+		}
+
+		for _, pod := range pods {
+			if seen[pod.GetId()] {
+				continue
+			}
+			seen[pod.GetId()] = true
+			merged = append(merged, pod)
+		}
+	}
+
+	return merged, nil
+}
+
+// CreateContainer dispatches to whichever backend owns podSandboxID and
+// records the same ownership for the resulting container.
+func (m *RuntimeManager) CreateContainer(podSandboxID string, config *kubeapi.ContainerConfig, sandboxConfig *kubeapi.PodSandboxConfig) (string, error) {
+	backendName, err := m.ownerOf(podSandboxID)
+	if err != nil {
+		return "", err
+	}
+
+	containerID, err := m.backends[backendName].CreateContainer(podSandboxID, config, sandboxConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.setOwner(containerID, backendName); err != nil {
+		glog.Errorf("Record backend ownership for container %q failed: %v", containerID, err)
+		return "", err
+	}
+
+	return containerID, nil
+}
+
+// StartContainer dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) StartContainer(containerID string) error {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return err
+	}
+
+	return backend.StartContainer(containerID)
+}
+
+// StopContainer dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) StopContainer(containerID string, timeout int64) error {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return err
+	}
+
+	return backend.StopContainer(containerID, timeout)
+}
+
+// RemoveContainer dispatches to whichever backend owns containerID, then
+// forgets the ownership record.
+func (m *RuntimeManager) RemoveContainer(containerID string) error {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return err
+	}
+
+	if err := backend.RemoveContainer(containerID); err != nil {
+		return err
+	}
+
+	return m.clearOwner(containerID)
+}
+
+// ListContainers merges the results of every backend, de-duplicating by ID.
+func (m *RuntimeManager) ListContainers(filter *kubeapi.ContainerFilter) ([]*kubeapi.Container, error) {
+	seen := make(map[string]bool)
+	var merged []*kubeapi.Container
+
+	for name, backend := range m.backends {
+		containers, err := backend.ListContainers(filter)
+		if err != nil {
+			glog.Errorf("ListContainers on %s backend failed: %v", name, err)
+			return nil, err
+		}
+
+		for _, c := range containers {
+			if seen[c.GetId()] {
+				continue
+			}
+			seen[c.GetId()] = true
+			merged = append(merged, c)
+		}
+	}
+
+	return merged, nil
+}
+
+// ContainerStatus dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) ContainerStatus(containerID string) (*kubeapi.ContainerStatus, error) {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.ContainerStatus(containerID)
+}
+
+// ExecSync dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) ExecSync(containerID string, cmd []string, timeout time.Duration) ([]byte, []byte, int32, error) {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return backend.ExecSync(containerID, cmd, timeout)
+}
+
+// Exec dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return err
+	}
+
+	return backend.Exec(containerID, cmd, stdin, stdout, stderr, tty, resize)
+}
+
+// Attach dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) Attach(containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return err
+	}
+
+	return backend.Attach(containerID, stdin, stdout, stderr, tty, resize)
+}
+
+// PortForward dispatches to whichever backend owns podSandboxID.
+func (m *RuntimeManager) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	backend, err := m.backendOf(podSandboxID)
+	if err != nil {
+		return err
+	}
+
+	return backend.PortForward(podSandboxID, port, stream)
+}
+
+// GetExec dispatches to whichever backend owns the container req names.
+func (m *RuntimeManager) GetExec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
+	backend, err := m.backendOf(req.GetContainerId())
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.GetExec(req)
+}
+
+// GetAttach dispatches to whichever backend owns the container req names.
+func (m *RuntimeManager) GetAttach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
+	backend, err := m.backendOf(req.GetContainerId())
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.GetAttach(req)
+}
+
+// GetPortForward dispatches to whichever backend owns the sandbox req names.
+func (m *RuntimeManager) GetPortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
+	backend, err := m.backendOf(req.GetPodSandboxId())
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.GetPortForward(req)
+}
+
+// ContainerStats dispatches to whichever backend owns containerID.
+func (m *RuntimeManager) ContainerStats(containerID string) (*kubeapi.ContainerStats, error) {
+	backend, err := m.backendOf(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.ContainerStats(containerID)
+}
+
+// ListContainerStats merges the results of every backend, de-duplicating by
+// container ID.
+func (m *RuntimeManager) ListContainerStats(filter *kubeapi.ContainerStatsFilter) ([]*kubeapi.ContainerStats, error) {
+	seen := make(map[string]bool)
+	var merged []*kubeapi.ContainerStats
+
+	for _, name := range backendNames {
+		stats, err := m.backends[name].ListContainerStats(filter)
+		if err != nil {
+			glog.Errorf("ListContainerStats on %s backend failed: %v", name, err)
+			return nil, err
+		}
+
+		for _, s := range stats {
+			if seen[s.GetAttributes().GetId()] {
+				continue
+			}
+			seen[s.GetAttributes().GetId()] = true
+			merged = append(merged, s)
+		}
+	}
+
+	return merged, nil
+}
+
+// UpdateRuntimeConfig fans the update out to every backend.
+func (m *RuntimeManager) UpdateRuntimeConfig(runtimeConfig *kubeapi.RuntimeConfig) error {
+	for name, backend := range m.backends {
+		if err := backend.UpdateRuntimeConfig(runtimeConfig); err != nil {
+			glog.Errorf("UpdateRuntimeConfig on %s backend failed: %v", name, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backendFor picks the backend name for a pod based on its annotations.
+// Pods are VM-isolated by hyperd unless explicitly opted out.
+func backendFor(annotations map[string]string) string {
+	if annotations[osContainerAnnotation] == "true" {
+		return backendRunc
+	}
+
+	return backendHyper
+}
+
+// backendOf looks up the Backend owning id.
+func (m *RuntimeManager) backendOf(id string) (Backend, error) {
+	name, err := m.ownerOf(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.backends[name], nil
+}
+
+// ownerOf looks up which backend name owns id.
+func (m *RuntimeManager) ownerOf(id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.owners[id]
+	if !ok {
+		return "", fmt.Errorf("no backend owns sandbox/container %q", id)
+	}
+
+	return name, nil
+}
+
+// setOwner records that backendName owns id, persisting the change.
+func (m *RuntimeManager) setOwner(id, backendName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.owners[id] = backendName
+	return m.store.Save(m.owners)
+}
+
+// clearOwner forgets the owner recorded for id, persisting the change.
+func (m *RuntimeManager) clearOwner(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.owners, id)
+	return m.store.Save(m.owners)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ownerFileName is where the sandbox/container -> backend map is persisted
+// under a RuntimeManager's state directory.
+const ownerFileName = "backend-owners.json"
+
+// ownerStore persists the sandbox/container -> backend-name map to disk so
+// RuntimeManager can keep dispatching correctly across a frakti restart.
+type ownerStore struct {
+	path string
+}
+
+func newOwnerStore(stateDir string) (*ownerStore, error) {
+	if err := os.MkdirAll(stateDir, 0750); err != nil {
+		return nil, err
+	}
+
+	return &ownerStore{path: filepath.Join(stateDir, ownerFileName)}, nil
+}
+
+// Load reads the persisted owner map, returning an empty map if none has
+// been written yet.
+func (s *ownerStore) Load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]string)
+	if err := json.Unmarshal(data, &owners); err != nil {
+		return nil, err
+	}
+
+	return owners, nil
+}
+
+// Save atomically writes owners to disk: write to a temp file in the same
+// directory, then rename over the real path, so a crash mid-write never
+// leaves a corrupt owner file behind.
+func (s *ownerStore) Save(owners map[string]string) error {
+	data, err := json.Marshal(owners)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package network sets up pod sandbox networking via CNI, the same way
+// dockershim and CRI-O do: a network namespace is created per sandbox, and
+// the configured CNI plugin chain is run with ADD on creation and DEL on
+// teardown.
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/golang/glog"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+const (
+	// defaultNetDir and defaultBinDir match the conventions dockershim and
+	// kubenet use, so a cluster's existing CNI config Just Works.
+	defaultNetDir = "/etc/cni/net.d"
+	defaultBinDir = "/opt/cni/bin"
+)
+
+// Config configures where CNI network configuration and plugin binaries
+// are loaded from.
+type Config struct {
+	// CNIConfDir is scanned for the CNI network configuration to use.
+	// Defaults to /etc/cni/net.d.
+	CNIConfDir string
+	// CNIBinDir is searched for the CNI plugin binaries the configuration
+	// references. Defaults to /opt/cni/bin.
+	CNIBinDir string
+}
+
+// Plugin drives CNI ADD/DEL for pod sandboxes.
+type Plugin struct {
+	confDir string
+	binDir  string
+	cniConf *libcni.NetworkConfigList
+	cni     *libcni.CNIConfig
+
+	// netNSLock guards netNS, the network namespaces SetUpPod created that
+	// TearDownPod still needs to DEL and remove. Without it, TearDownPod
+	// has no way to find the namespace it's supposed to clean up, and every
+	// sandbox leaks its bind-mounted netns under /var/run/netns.
+	netNSLock sync.Mutex
+	netNS     map[string]ns.NetNS
+}
+
+// NewPlugin loads the default CNI network from config's conf dir. The
+// plugin is usable (Status reports not-ready) even if no configuration is
+// present yet, so frakti can start before the CNI config is dropped in
+// place by cluster bring-up.
+func NewPlugin(config Config) *Plugin {
+	confDir := config.CNIConfDir
+	if confDir == "" {
+		confDir = defaultNetDir
+	}
+	binDir := config.CNIBinDir
+	if binDir == "" {
+		binDir = defaultBinDir
+	}
+
+	p := &Plugin{
+		confDir: confDir,
+		binDir:  binDir,
+		cni:     &libcni.CNIConfig{Path: []string{binDir}},
+		netNS:   make(map[string]ns.NetNS),
+	}
+
+	if netConf, err := loadDefaultNetworkConfig(confDir); err != nil {
+		glog.Warningf("Load CNI network config from %q failed, network will be reported not-ready until it appears: %v", confDir, err)
+	} else {
+		p.cniConf = netConf
+	}
+
+	return p
+}
+
+// Status reports whether a CNI network configuration and its plugin
+// binaries are in place.
+func (p *Plugin) Status() (ready bool, reason, message string) {
+	if p.cniConf == nil {
+		netConf, err := loadDefaultNetworkConfig(p.confDir)
+		if err != nil {
+			return false, "NetworkPluginNotReady", fmt.Sprintf("cni: no usable network config in %s: %v", p.confDir, err)
+		}
+		p.cniConf = netConf
+	}
+
+	for _, plugin := range p.cniConf.Plugins {
+		binPath := filepath.Join(p.binDir, plugin.Network.Type)
+		if _, err := os.Stat(binPath); err != nil {
+			return false, "NetworkPluginNotReady", fmt.Sprintf("cni: plugin binary %q not found in %s: %v", plugin.Network.Type, p.binDir, err)
+		}
+	}
+
+	return true, "", ""
+}
+
+// SetUpPod creates a network namespace for podSandboxID and runs CNI ADD
+// against it, returning the IP address the pod was assigned.
+func (p *Plugin) SetUpPod(namespace, name, podSandboxID string, annotations map[string]string, portMappings []*kubeapi.PortMapping) (string, error) {
+	if p.cniConf == nil {
+		netConf, err := loadDefaultNetworkConfig(p.confDir)
+		if err != nil {
+			return "", fmt.Errorf("cni: no network configuration available: %v", err)
+		}
+		p.cniConf = netConf
+	}
+
+	netns, err := ns.NewNS()
+	if err != nil {
+		return "", fmt.Errorf("cni: create network namespace for sandbox %q failed: %v", podSandboxID, err)
+	}
+
+	rt := &libcni.RuntimeConf{
+		ContainerID: podSandboxID,
+		NetNS:       netns.Path(),
+		IfName:      "eth0",
+		Args: [][2]string{
+			{"K8S_POD_NAMESPACE", namespace},
+			{"K8S_POD_NAME", name},
+			{"K8S_POD_INFRA_CONTAINER_ID", podSandboxID},
+		},
+		CapabilityArgs: map[string]interface{}{
+			"portMappings": toCNIPortMappings(portMappings),
+		},
+	}
+
+	result, err := p.cni.AddNetworkList(p.cniConf, rt)
+	if err != nil {
+		if unmountErr := ns.UnmountNS(netns); unmountErr != nil {
+			glog.Warningf("Unmount network namespace for sandbox %q failed: %v", podSandboxID, unmountErr)
+		}
+		netns.Close()
+		return "", fmt.Errorf("cni: ADD for sandbox %q failed: %v", podSandboxID, err)
+	}
+
+	// The namespace now has a live network attached to it, so it must be
+	// kept around (and handed back to TearDownPod) regardless of what
+	// happens below, or the later DEL would have nothing to tear down.
+	p.netNSLock.Lock()
+	p.netNS[podSandboxID] = netns
+	p.netNSLock.Unlock()
+
+	cniResult, err := current.NewResultFromResult(result)
+	if err != nil {
+		return "", fmt.Errorf("cni: parse result for sandbox %q failed: %v", podSandboxID, err)
+	}
+
+	for _, ip := range cniResult.IPs {
+		return ip.Address.IP.String(), nil
+	}
+
+	return "", fmt.Errorf("cni: ADD for sandbox %q returned no IP", podSandboxID)
+}
+
+// NetNS returns the path of the network namespace SetUpPod created for
+// podSandboxID, so a backend can join a sandbox's other processes to it.
+// The second return value is false if SetUpPod hasn't been called for
+// podSandboxID (e.g. it's hostNetwork, or has already been torn down).
+func (p *Plugin) NetNS(podSandboxID string) (string, bool) {
+	p.netNSLock.Lock()
+	defer p.netNSLock.Unlock()
+
+	netns, ok := p.netNS[podSandboxID]
+	if !ok {
+		return "", false
+	}
+
+	return netns.Path(), true
+}
+
+// TearDownPod runs CNI DEL against podSandboxID's network namespace.
+// portMappings must be the same mappings passed to SetUpPod, since the
+// portmap CNI plugin needs them again on DEL to find the host
+// ports/iptables rules it installed for the sandbox.
+func (p *Plugin) TearDownPod(namespace, name, podSandboxID string, portMappings []*kubeapi.PortMapping) error {
+	if p.cniConf == nil {
+		return nil
+	}
+
+	p.netNSLock.Lock()
+	netns, ok := p.netNS[podSandboxID]
+	delete(p.netNS, podSandboxID)
+	p.netNSLock.Unlock()
+
+	var netnsPath string
+	if ok {
+		netnsPath = netns.Path()
+	}
+
+	rt := &libcni.RuntimeConf{
+		ContainerID: podSandboxID,
+		NetNS:       netnsPath,
+		IfName:      "eth0",
+		Args: [][2]string{
+			{"K8S_POD_NAMESPACE", namespace},
+			{"K8S_POD_NAME", name},
+			{"K8S_POD_INFRA_CONTAINER_ID", podSandboxID},
+		},
+		CapabilityArgs: map[string]interface{}{
+			"portMappings": toCNIPortMappings(portMappings),
+		},
+	}
+
+	if err := p.cni.DelNetworkList(p.cniConf, rt); err != nil {
+		return fmt.Errorf("cni: DEL for sandbox %q failed: %v", podSandboxID, err)
+	}
+
+	if ok {
+		if err := ns.UnmountNS(netns); err != nil {
+			glog.Warningf("Unmount network namespace for sandbox %q failed: %v", podSandboxID, err)
+		}
+		if err := netns.Close(); err != nil {
+			glog.Warningf("Close network namespace for sandbox %q failed: %v", podSandboxID, err)
+		}
+	}
+
+	return nil
+}
+
+// loadDefaultNetworkConfig returns the first CNI network configuration
+// found in confDir, in file-name order, matching the CNI convention that
+// the lexicographically first .conf/.conflist file is the default network.
+func loadDefaultNetworkConfig(confDir string) (*libcni.NetworkConfigList, error) {
+	files, err := libcni.ConfFiles(confDir, []string{".conf", ".conflist", ".json"})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no CNI configuration found in %s", confDir)
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		if filepath.Ext(f) == ".conflist" {
+			if conf, err := libcni.ConfListFromFile(f); err == nil {
+				return conf, nil
+			}
+			continue
+		}
+
+		conf, err := libcni.ConfFromFile(f)
+		if err != nil {
+			continue
+		}
+		confList, err := libcni.ConfListFromConf(conf)
+		if err != nil {
+			continue
+		}
+		return confList, nil
+	}
+
+	return nil, fmt.Errorf("no usable CNI configuration found in %s", confDir)
+}
+
+// toCNIPortMappings converts CRI port mappings into the shape the portmap
+// CNI plugin expects in its portMappings capability argument.
+func toCNIPortMappings(portMappings []*kubeapi.PortMapping) []map[string]interface{} {
+	mappings := make([]map[string]interface{}, 0, len(portMappings))
+	for _, pm := range portMappings {
+		protocol := "tcp"
+		if pm.GetProtocol() == kubeapi.Protocol_UDP {
+			protocol = "udp"
+		}
+
+		mappings = append(mappings, map[string]interface{}{
+			"hostPort":      pm.GetHostPort(),
+			"containerPort": pm.GetContainerPort(),
+			"protocol":      protocol,
+		})
+	}
+
+	return mappings
+}
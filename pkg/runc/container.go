@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// CreateContainer creates a new container in podSandboxID, joining the
+// sandbox's PID/IPC/UTS/network namespaces.
+//
+// This backend has no image store of its own: config.GetImage().GetImage()
+// is expected to already be the absolute path of an extracted rootfs
+// directory on disk (how it got there, e.g. a separate image-puller
+// sidecar, is outside this package's scope), rather than a registry
+// reference like "busybox:latest".
+func (r *Runtime) CreateContainer(podSandboxID string, config *kubeapi.ContainerConfig, sandboxConfig *kubeapi.PodSandboxConfig) (string, error) {
+	rootfs := config.GetImage().GetImage()
+	if _, err := os.Stat(rootfs); err != nil {
+		glog.Errorf("Rootfs %q for container in sandbox %q not usable: %v", rootfs, podSandboxID, err)
+		return "", fmt.Errorf("runc: rootfs %q not usable: %v", rootfs, err)
+	}
+
+	r.sandboxPidsLock.Lock()
+	sandboxPid, ok := r.sandboxPids[podSandboxID]
+	r.sandboxPidsLock.Unlock()
+	if !ok {
+		return "", fmt.Errorf("runc: sandbox %q is not running", podSandboxID)
+	}
+
+	containerSpec, err := buildContainerSpec(config, rootfs, sandboxPid)
+	if err != nil {
+		glog.Errorf("Build container spec for sandbox %q failed: %v", podSandboxID, err)
+		return "", err
+	}
+
+	id, err := newID()
+	if err != nil {
+		glog.Errorf("Generate container ID failed: %v", err)
+		return "", err
+	}
+
+	bundleDir := r.containerBundleDir(id)
+	if err := os.MkdirAll(bundleDir, 0750); err != nil {
+		glog.Errorf("Create bundle directory for container %q failed: %v", id, err)
+		return "", err
+	}
+
+	if err := writeSpec(bundleDir, containerSpec); err != nil {
+		glog.Errorf("Write OCI spec for container %q failed: %v", id, err)
+		os.RemoveAll(bundleDir)
+		return "", err
+	}
+
+	if err := r.checkpoints.WriteContainer(id, podSandboxID, config); err != nil {
+		glog.Errorf("Checkpoint container %q failed: %v", id, err)
+		os.RemoveAll(bundleDir)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// StartContainer starts a previously created container.
+func (r *Runtime) StartContainer(containerID string) error {
+	if err := runcRun(containerID, r.containerBundleDir(containerID)); err != nil {
+		glog.Errorf("Start container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// StopContainer stops a running container, giving it timeout seconds to
+// exit gracefully before being force killed.
+func (r *Runtime) StopContainer(containerID string, timeout int64) error {
+	if err := stopRuncProcess(containerID, time.Duration(timeout)*time.Second); err != nil {
+		glog.Errorf("Stop container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveContainer removes a stopped container.
+func (r *Runtime) RemoveContainer(containerID string) error {
+	if err := runcDelete(containerID, true); err != nil {
+		glog.Errorf("Remove container %q failed: %v", containerID, err)
+		return err
+	}
+
+	r.statsCache.Remove(containerID)
+
+	if err := r.checkpoints.RemoveContainer(containerID); err != nil {
+		glog.Errorf("Remove checkpoint for container %q failed: %v", containerID, err)
+		return err
+	}
+
+	return os.RemoveAll(r.containerBundleDir(containerID))
+}
+
+// ListContainers returns a list of containers matching filter.
+func (r *Runtime) ListContainers(filter *kubeapi.ContainerFilter) ([]*kubeapi.Container, error) {
+	ids, err := r.checkpoints.ListContainerIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed containers failed: %v", err)
+		return nil, err
+	}
+
+	items := make([]*kubeapi.Container, 0, len(ids))
+	for _, id := range ids {
+		checkpoint, err := r.checkpoints.ReadContainer(id)
+		if err != nil {
+			glog.Warningf("Read checkpoint for container %q failed, skipping: %v", id, err)
+			continue
+		}
+
+		state, err := getRuncState(id)
+		if err != nil {
+			glog.Warningf("Get state for container %q failed, skipping: %v", id, err)
+			continue
+		}
+		containerState := toContainerState(state.Status)
+
+		if filter != nil {
+			if filter.Id != nil && id != filter.GetId() {
+				continue
+			}
+			if filter.PodSandboxId != nil && checkpoint.PodSandboxID != filter.GetPodSandboxId() {
+				continue
+			}
+			if filter.State != nil && containerState != filter.GetState() {
+				continue
+			}
+			if filter.LabelSelector != nil && !inMap(filter.LabelSelector, checkpoint.Config.GetLabels()) {
+				continue
+			}
+		}
+
+		config := checkpoint.Config
+		items = append(items, &kubeapi.Container{
+			Id:           proto.String(id),
+			PodSandboxId: proto.String(checkpoint.PodSandboxID),
+			Metadata:     config.GetMetadata(),
+			Image:        config.GetImage(),
+			ImageRef:     proto.String(config.GetImage().GetImage()),
+			State:        &containerState,
+			CreatedAt:    proto.Int64(0),
+			Labels:       config.GetLabels(),
+			Annotations:  config.GetAnnotations(),
+		})
+	}
+
+	return items, nil
+}
+
+// ContainerStatus returns the status of containerID.
+func (r *Runtime) ContainerStatus(containerID string) (*kubeapi.ContainerStatus, error) {
+	checkpoint, err := r.checkpoints.ReadContainer(containerID)
+	if err != nil {
+		glog.Errorf("Read checkpoint for container %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	state, err := getRuncState(containerID)
+	if err != nil {
+		glog.Errorf("Get state for container %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	containerState := toContainerState(state.Status)
+	config := checkpoint.Config
+	return &kubeapi.ContainerStatus{
+		Id:          proto.String(containerID),
+		Metadata:    config.GetMetadata(),
+		State:       &containerState,
+		CreatedAt:   proto.Int64(0),
+		Image:       config.GetImage(),
+		ImageRef:    proto.String(config.GetImage().GetImage()),
+		Labels:      config.GetLabels(),
+		Annotations: config.GetAnnotations(),
+		Mounts:      config.GetMounts(),
+	}, nil
+}
+
+// toContainerState maps a runc process status to the CRI ContainerState.
+func toContainerState(status runcStatus) kubeapi.ContainerState {
+	switch status {
+	case runcStatusRunning:
+		return kubeapi.ContainerState_CONTAINER_RUNNING
+	case runcStatusCreated:
+		return kubeapi.ContainerState_CONTAINER_CREATED
+	case runcStatusStopped:
+		return kubeapi.ContainerState_CONTAINER_EXITED
+	default:
+		return kubeapi.ContainerState_CONTAINER_UNKNOWN
+	}
+}
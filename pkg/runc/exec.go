@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/client-go/tools/remotecommand"
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// ExecSync runs cmd inside containerID via `runc exec` and waits for it to
+// finish, capturing its output.
+func (r *Runtime) ExecSync(containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error) {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	args := append([]string{"exec", containerID}, cmd...)
+	execCmd := exec.CommandContext(ctx, "runc", args...)
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	execCmd.Stdout = &stdoutBuf
+	execCmd.Stderr = &stderrBuf
+
+	runErr := execCmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), -1, fmt.Errorf("exec %v in container %q timed out after %v", cmd, containerID, timeout)
+	}
+
+	if runErr == nil {
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), 0, nil
+	}
+
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		return stdoutBuf.Bytes(), stderrBuf.Bytes(), int32(exitErr.ExitCode()), nil
+	}
+
+	glog.Errorf("ExecSync %v in container %q failed: %v", cmd, containerID, runErr)
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), -1, runErr
+}
+
+// Exec executes cmd in containerID, wiring stdin/stdout/stderr to the
+// `runc exec` process. Unlike hyperd's ExecVM, runc has no RPC to resize an
+// already-started exec's tty, so resize events are drained (to avoid
+// blocking the caller) but not acted on.
+func (r *Runtime) Exec(containerID string, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	if resize != nil {
+		go func() {
+			for range resize {
+			}
+		}()
+	}
+
+	args := []string{"exec"}
+	if tty {
+		args = append(args, "-t")
+	}
+	args = append(args, containerID)
+	args = append(args, cmd...)
+
+	execCmd := exec.Command("runc", args...)
+	execCmd.Stdin = stdin
+	execCmd.Stdout = stdout
+	execCmd.Stderr = stderr
+
+	if err := execCmd.Run(); err != nil {
+		glog.Errorf("Exec %v in container %q failed: %v", cmd, containerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// Attach is not supported by this backend: a container's init process is
+// started once by `runc run` with its stdio already wired to whatever the
+// first caller passed, and runc has no mechanism to hand a second caller a
+// handle to that same process's original stdio streams.
+func (r *Runtime) Attach(containerID string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return fmt.Errorf("runc: attach is not supported, container %q's stdio is not reattachable", containerID)
+}
+
+// PortForward copies data between stream and port inside podSandboxID's
+// network namespace, via nsenter into the namespace network.Plugin set up
+// for the sandbox.
+func (r *Runtime) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	netNSPath, ok := r.networkPlugin.NetNS(podSandboxID)
+	if !ok {
+		return fmt.Errorf("runc: sandbox %q has no network namespace to forward into", podSandboxID)
+	}
+
+	cmd := exec.Command("nsenter", fmt.Sprintf("--net=%s", netNSPath), "socat", "-", fmt.Sprintf("TCP4:127.0.0.1:%d", port))
+	cmd.Stdin = stream
+	cmd.Stdout = stream
+
+	if err := cmd.Run(); err != nil {
+		glog.Errorf("PortForward to sandbox %q port %d failed: %v", podSandboxID, port, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetExec returns the single-use URL the kubelet should dial to redeem an
+// exec stream.
+func (r *Runtime) GetExec(req *kubeapi.ExecRequest) (*kubeapi.ExecResponse, error) {
+	return r.streamingServer.GetExec(req)
+}
+
+// GetAttach returns the single-use URL the kubelet should dial to redeem an
+// attach stream.
+func (r *Runtime) GetAttach(req *kubeapi.AttachRequest) (*kubeapi.AttachResponse, error) {
+	return r.streamingServer.GetAttach(req)
+}
+
+// GetPortForward returns the single-use URL the kubelet should dial to
+// redeem a port-forward stream.
+func (r *Runtime) GetPortForward(req *kubeapi.PortForwardRequest) (*kubeapi.PortForwardResponse, error) {
+	return r.streamingServer.GetPortForward(req)
+}
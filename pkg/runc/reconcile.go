@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"github.com/golang/glog"
+)
+
+// reconcileCheckpoints runs once at startup to bring in-memory state back
+// in line with what runc actually has running and garbage collect
+// checkpoints for sandboxes runc no longer knows about. Unlike hyperd,
+// runc has no independent source of a sandbox's IP, and the IP is not
+// checkpointed either; instead sandboxPids is repopulated from `runc state`
+// so CreateContainer keeps working, and podIPs gets an entry with an empty
+// IP for every checkpointed sandbox whose config is not hostNetwork, so a
+// subsequent DeletePodSandbox still knows to tear its CNI network down.
+func (r *Runtime) reconcileCheckpoints() error {
+	checkpointedSandboxes, err := r.checkpoints.ListSandboxIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed sandboxes failed: %v", err)
+		return err
+	}
+
+	for _, podSandboxID := range checkpointedSandboxes {
+		config, err := r.checkpoints.ReadSandbox(podSandboxID)
+		if err != nil {
+			glog.Warningf("Read checkpoint for sandbox %q failed, garbage collecting: %v", podSandboxID, err)
+			r.checkpoints.RemoveSandbox(podSandboxID)
+			continue
+		}
+
+		state, err := getRuncState(podSandboxID)
+		if err != nil {
+			glog.Infof("Garbage collecting checkpoint for sandbox %q, no longer present in runc: %v", podSandboxID, err)
+			if err := r.checkpoints.RemoveSandbox(podSandboxID); err != nil {
+				glog.Errorf("Garbage collect checkpoint for sandbox %q failed: %v", podSandboxID, err)
+			}
+			continue
+		}
+
+		r.sandboxPidsLock.Lock()
+		r.sandboxPids[podSandboxID] = state.Pid
+		r.sandboxPidsLock.Unlock()
+
+		if !isHostNetwork(config) {
+			// The CNI-assigned IP itself isn't checkpointed, only that the
+			// sandbox has a network to tear down; PodSandboxStatus will
+			// report an empty IP for an adopted sandbox until it's
+			// recreated, but DeletePodSandbox will still call TearDownPod
+			// for it instead of silently skipping network cleanup.
+			r.podIPsLock.Lock()
+			r.podIPs[podSandboxID] = ""
+			r.podIPsLock.Unlock()
+			glog.Infof("Adopted sandbox %q from its checkpoint, pid %d", podSandboxID, state.Pid)
+		}
+	}
+
+	checkpointedContainers, err := r.checkpoints.ListContainerIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed containers failed: %v", err)
+		return err
+	}
+	for _, containerID := range checkpointedContainers {
+		if _, err := getRuncState(containerID); err != nil {
+			glog.Infof("Garbage collecting checkpoint for container %q, no longer present in runc: %v", containerID, err)
+			if err := r.checkpoints.RemoveContainer(containerID); err != nil {
+				glog.Errorf("Garbage collect checkpoint for container %q failed: %v", containerID, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,499 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runc is the shared-kernel counterpart to pkg/hyper: it runs pod
+// sandboxes and containers directly on the host via runc instead of inside
+// a hyperd-managed VM. It exists so pkg/manager can route pods that don't
+// need VM isolation (OSContainer pods) to something lighter weight than
+// hyperd.
+//
+// A sandbox is a long-running "pause" process (`/bin/sleep infinity`)
+// created with its own PID/IPC/UTS/mount namespaces and, unless the pod is
+// hostNetwork, the network namespace network.Plugin set up for it via CNI.
+// Containers join that same PID/IPC/UTS/network namespace set and get their
+// own mount namespace and rootfs.
+//
+// This backend has no image store of its own: a container's image ref is
+// expected to already name a directory on disk holding an extracted rootfs
+// (how it gets there is outside this package's scope), and a sandbox's
+// "pause" process runs directly against the host's own root filesystem,
+// read-only, since it never execs anything beyond /bin/sleep.
+package runc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+
+	"k8s.io/frakti/pkg/hyper/checkpoint"
+	"k8s.io/frakti/pkg/hyper/stats"
+	"k8s.io/frakti/pkg/hyper/streaming"
+	"k8s.io/frakti/pkg/network"
+)
+
+const (
+	runcRuntimeName = "runc"
+	runcAPIVersion  = "1.0.0"
+	ociVersion      = "1.0.0"
+
+	sandboxesSubdir  = "sandboxes"
+	containersSubdir = "containers"
+
+	// killTimeout bounds how long StopPodSandbox waits for the sandbox's
+	// SIGTERM'd pause process to exit before escalating to SIGKILL.
+	killTimeout = 10 * time.Second
+)
+
+// Runtime is the shared-kernel implementation of the CRI runtime service.
+// It satisfies the same method set as hyper.Runtime so pkg/manager can
+// treat the two backends interchangeably.
+type Runtime struct {
+	// rootDir holds the runc bundle directories and checkpointed CRI
+	// metadata for every sandbox/container this backend owns.
+	rootDir string
+
+	networkPlugin   *network.Plugin
+	streamingServer streaming.Server
+	checkpoints     *checkpoint.Store
+	statsCache      *stats.Cache
+
+	// podIPs caches the IP CNI assigned to each non-hostNetwork sandbox.
+	podIPsLock sync.Mutex
+	podIPs     map[string]string
+
+	// sandboxPids caches the pause process's PID for each live sandbox, so
+	// CreateContainer can join a container into its namespaces by path
+	// (/proc/<pid>/ns/...) without having to shell out to `runc state`
+	// every time.
+	sandboxPidsLock sync.Mutex
+	sandboxPids     map[string]int
+}
+
+// NewRuntime creates a new runc-backed Runtime. streamingServerAddr must be
+// reachable from the kubelet, since it is embedded in the URLs handed back
+// by GetExec/GetAttach/GetPortForward. networkConfig configures the CNI
+// plugin used to network non-hostNetwork sandboxes. rootDir is where bundle
+// directories and checkpointed CRI metadata are kept.
+func NewRuntime(rootDir, streamingServerAddr string, streamingIdleTimeout time.Duration, networkConfig network.Config) (*Runtime, error) {
+	for _, dir := range []string{filepath.Join(rootDir, sandboxesSubdir), filepath.Join(rootDir, containersSubdir)} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			glog.Errorf("Create runc bundle directory %q failed: %v", dir, err)
+			return nil, err
+		}
+	}
+
+	checkpoints, err := checkpoint.NewStore(rootDir)
+	if err != nil {
+		glog.Errorf("Initialize checkpoint store at %q failed: %v", rootDir, err)
+		return nil, err
+	}
+
+	r := &Runtime{
+		rootDir:       rootDir,
+		networkPlugin: network.NewPlugin(networkConfig),
+		checkpoints:   checkpoints,
+		statsCache:    stats.NewCache(0),
+		podIPs:        make(map[string]string),
+		sandboxPids:   make(map[string]int),
+	}
+
+	if err := r.reconcileCheckpoints(); err != nil {
+		glog.Errorf("Reconcile checkpoints at %q failed: %v", rootDir, err)
+		return nil, err
+	}
+
+	streamingServer, err := streaming.NewServer(streaming.Config{
+		Addr:        streamingServerAddr,
+		IdleTimeout: streamingIdleTimeout,
+	}, r)
+	if err != nil {
+		glog.Errorf("Initialize streaming server failed: %v", err)
+		return nil, err
+	}
+	r.streamingServer = streamingServer
+
+	go func() {
+		if err := streamingServer.Start(); err != nil {
+			glog.Fatalf("Streaming server stopped unexpectedly: %v", err)
+		}
+	}()
+
+	return r, nil
+}
+
+// Version returns the runtime name, runtime version and runtime API version.
+func (r *Runtime) Version() (string, string, string, error) {
+	out, err := execRuncOutput("--version")
+	if err != nil {
+		glog.Errorf("Get runc version failed: %v", err)
+		return "", "", "", err
+	}
+
+	return runcRuntimeName, out, runcAPIVersion, nil
+}
+
+// Status returns the status of the runtime: ready as long as the runc
+// binary is present and its own network plugin is ready.
+func (r *Runtime) Status() (*kubeapi.RuntimeStatus, error) {
+	runtimeReady := &kubeapi.RuntimeCondition{
+		Type:   proto.String(kubeapi.RuntimeReady),
+		Status: proto.Bool(true),
+	}
+	if _, err := execRuncOutput("--version"); err != nil {
+		runtimeReady.Status = proto.Bool(false)
+		runtimeReady.Reason = proto.String("RuncNotReady")
+		runtimeReady.Message = proto.String(fmt.Sprintf("runc: runc binary not usable: %v", err))
+	}
+
+	networkReady := &kubeapi.RuntimeCondition{
+		Type:   proto.String(kubeapi.NetworkReady),
+		Status: proto.Bool(true),
+	}
+	if ready, reason, message := r.networkPlugin.Status(); !ready {
+		networkReady.Status = proto.Bool(false)
+		networkReady.Reason = proto.String(reason)
+		networkReady.Message = proto.String(message)
+	}
+
+	return &kubeapi.RuntimeStatus{Conditions: []*kubeapi.RuntimeCondition{runtimeReady, networkReady}}, nil
+}
+
+// isHostNetwork reports whether config opts the sandbox out of CNI
+// networking in favor of sharing the host's network namespace.
+func isHostNetwork(config *kubeapi.PodSandboxConfig) bool {
+	return config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork()
+}
+
+// RunPodSandbox creates and starts a pause-container sandbox via runc.
+func (r *Runtime) RunPodSandbox(config *kubeapi.PodSandboxConfig) (string, error) {
+	id, err := newID()
+	if err != nil {
+		glog.Errorf("Generate sandbox ID failed: %v", err)
+		return "", err
+	}
+
+	var netNSPath, podIP string
+	hasNetwork := !isHostNetwork(config)
+	if hasNetwork {
+		metadata := config.GetMetadata()
+		podIP, err = r.networkPlugin.SetUpPod(metadata.GetNamespace(), metadata.GetName(), id, config.GetAnnotations(), config.GetPortMappings())
+		if err != nil {
+			glog.Errorf("Set up pod network for sandbox %q failed: %v", id, err)
+			return "", err
+		}
+		netNSPath, _ = r.networkPlugin.NetNS(id)
+	}
+
+	bundleDir := r.sandboxBundleDir(id)
+	if err := os.MkdirAll(bundleDir, 0750); err != nil {
+		glog.Errorf("Create bundle directory for sandbox %q failed: %v", id, err)
+		r.tearDownSandboxNetwork(config, id, hasNetwork)
+		return "", err
+	}
+
+	if err := writeSpec(bundleDir, buildSandboxSpec(config, netNSPath)); err != nil {
+		glog.Errorf("Write OCI spec for sandbox %q failed: %v", id, err)
+		r.tearDownSandboxNetwork(config, id, hasNetwork)
+		return "", err
+	}
+
+	if err := runcRun(id, bundleDir); err != nil {
+		glog.Errorf("Run sandbox %q failed: %v", id, err)
+		r.tearDownSandboxNetwork(config, id, hasNetwork)
+		return "", err
+	}
+
+	state, err := getRuncState(id)
+	if err != nil {
+		glog.Errorf("Get state for sandbox %q failed: %v", id, err)
+		runcDelete(id, true)
+		r.tearDownSandboxNetwork(config, id, hasNetwork)
+		return "", err
+	}
+
+	r.sandboxPidsLock.Lock()
+	r.sandboxPids[id] = state.Pid
+	r.sandboxPidsLock.Unlock()
+
+	if hasNetwork {
+		r.podIPsLock.Lock()
+		r.podIPs[id] = podIP
+		r.podIPsLock.Unlock()
+	}
+
+	if err := r.checkpoints.WriteSandbox(id, config); err != nil {
+		glog.Errorf("Checkpoint sandbox %q failed: %v", id, err)
+		runcDelete(id, true)
+		r.tearDownSandboxNetwork(config, id, hasNetwork)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// tearDownSandboxNetwork undoes SetUpPod for a sandbox that failed to
+// finish starting, best-effort.
+func (r *Runtime) tearDownSandboxNetwork(config *kubeapi.PodSandboxConfig, id string, hasNetwork bool) {
+	if !hasNetwork {
+		return
+	}
+
+	r.podIPsLock.Lock()
+	delete(r.podIPs, id)
+	r.podIPsLock.Unlock()
+
+	metadata := config.GetMetadata()
+	if err := r.networkPlugin.TearDownPod(metadata.GetNamespace(), metadata.GetName(), id, config.GetPortMappings()); err != nil {
+		glog.Warningf("Tear down pod network for sandbox %q failed: %v", id, err)
+	}
+}
+
+// StopPodSandbox stops the sandbox's pause process and force stops every
+// container checkpointed under it.
+func (r *Runtime) StopPodSandbox(podSandboxID string) error {
+	for _, containerID := range r.containerIDsOf(podSandboxID) {
+		if err := r.StopContainer(containerID, 0); err != nil {
+			glog.Errorf("Stop container %q in sandbox %q failed: %v", containerID, podSandboxID, err)
+			return err
+		}
+	}
+
+	if err := stopRuncProcess(podSandboxID, killTimeout); err != nil {
+		glog.Errorf("Stop sandbox %q failed: %v", podSandboxID, err)
+		return err
+	}
+
+	return nil
+}
+
+// DeletePodSandbox force removes every container checkpointed under the
+// sandbox, then deletes the sandbox itself.
+func (r *Runtime) DeletePodSandbox(podSandboxID string) error {
+	for _, containerID := range r.containerIDsOf(podSandboxID) {
+		if err := r.RemoveContainer(containerID); err != nil {
+			glog.Errorf("Remove container %q in sandbox %q failed: %v", containerID, podSandboxID, err)
+			return err
+		}
+	}
+
+	if err := runcDelete(podSandboxID, true); err != nil {
+		glog.Errorf("Delete sandbox %q failed: %v", podSandboxID, err)
+		return err
+	}
+
+	r.sandboxPidsLock.Lock()
+	delete(r.sandboxPids, podSandboxID)
+	r.sandboxPidsLock.Unlock()
+
+	r.podIPsLock.Lock()
+	_, hadNetwork := r.podIPs[podSandboxID]
+	delete(r.podIPs, podSandboxID)
+	r.podIPsLock.Unlock()
+
+	checkpointedConfig, checkpointErr := r.checkpoints.ReadSandbox(podSandboxID)
+	if hadNetwork {
+		if checkpointErr != nil {
+			glog.Errorf("Read checkpoint for sandbox %q failed, network cleanup skipped: %v", podSandboxID, checkpointErr)
+		} else {
+			metadata := checkpointedConfig.GetMetadata()
+			if err := r.networkPlugin.TearDownPod(metadata.GetNamespace(), metadata.GetName(), podSandboxID, checkpointedConfig.GetPortMappings()); err != nil {
+				glog.Errorf("Tear down pod network for sandbox %q failed: %v", podSandboxID, err)
+			}
+		}
+	}
+
+	if err := r.checkpoints.RemoveSandbox(podSandboxID); err != nil {
+		glog.Errorf("Remove checkpoint for sandbox %q failed: %v", podSandboxID, err)
+		return err
+	}
+
+	return os.RemoveAll(r.sandboxBundleDir(podSandboxID))
+}
+
+// containerIDsOf returns the IDs of every checkpointed container belonging
+// to podSandboxID, best-effort: a list failure yields no containers rather
+// than failing the sandbox operation calling it.
+func (r *Runtime) containerIDsOf(podSandboxID string) []string {
+	ids, err := r.checkpoints.ListContainerIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed containers for sandbox %q failed: %v", podSandboxID, err)
+		return nil
+	}
+
+	var matched []string
+	for _, id := range ids {
+		checkpoint, err := r.checkpoints.ReadContainer(id)
+		if err != nil || checkpoint.PodSandboxID != podSandboxID {
+			continue
+		}
+		matched = append(matched, id)
+	}
+
+	return matched
+}
+
+// PodSandboxStatus returns the status of podSandboxID.
+func (r *Runtime) PodSandboxStatus(podSandboxID string) (*kubeapi.PodSandboxStatus, error) {
+	config, err := r.checkpoints.ReadSandbox(podSandboxID)
+	if err != nil {
+		glog.Errorf("Read checkpoint for sandbox %q failed: %v", podSandboxID, err)
+		return nil, err
+	}
+
+	state, err := getRuncState(podSandboxID)
+	if err != nil {
+		glog.Errorf("Get state for sandbox %q failed: %v", podSandboxID, err)
+		return nil, err
+	}
+
+	r.podIPsLock.Lock()
+	podIP := r.podIPs[podSandboxID]
+	r.podIPsLock.Unlock()
+
+	sandboxState := toPodSandboxState(state.Status)
+	metadata := config.GetMetadata()
+	return &kubeapi.PodSandboxStatus{
+		Id:          proto.String(podSandboxID),
+		Metadata:    metadata,
+		State:       &sandboxState,
+		Network:     &kubeapi.PodSandboxNetworkStatus{Ip: proto.String(podIP)},
+		CreatedAt:   proto.Int64(0),
+		Labels:      config.GetLabels(),
+		Annotations: config.GetAnnotations(),
+	}, nil
+}
+
+// ListPodSandbox returns a list of sandboxes owned by this backend.
+func (r *Runtime) ListPodSandbox(filter *kubeapi.PodSandboxFilter) ([]*kubeapi.PodSandbox, error) {
+	ids, err := r.checkpoints.ListSandboxIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed sandboxes failed: %v", err)
+		return nil, err
+	}
+
+	items := make([]*kubeapi.PodSandbox, 0, len(ids))
+	for _, id := range ids {
+		config, err := r.checkpoints.ReadSandbox(id)
+		if err != nil {
+			glog.Warningf("Read checkpoint for sandbox %q failed, skipping: %v", id, err)
+			continue
+		}
+
+		state, err := getRuncState(id)
+		if err != nil {
+			glog.Warningf("Get state for sandbox %q failed, skipping: %v", id, err)
+			continue
+		}
+		sandboxState := toPodSandboxState(state.Status)
+
+		if filter != nil {
+			if filter.Id != nil && id != filter.GetId() {
+				continue
+			}
+			if filter.State != nil && sandboxState != filter.GetState() {
+				continue
+			}
+			if filter.LabelSelector != nil && !inMap(filter.LabelSelector, config.GetLabels()) {
+				continue
+			}
+		}
+
+		items = append(items, &kubeapi.PodSandbox{
+			Id:        proto.String(id),
+			Metadata:  config.GetMetadata(),
+			Labels:    config.GetLabels(),
+			State:     &sandboxState,
+			CreatedAt: proto.Int64(0),
+		})
+	}
+
+	return items, nil
+}
+
+// UpdateRuntimeConfig updates runtime configuration if specified. This
+// backend has nothing to reconfigure.
+func (r *Runtime) UpdateRuntimeConfig(runtimeConfig *kubeapi.RuntimeConfig) error {
+	return nil
+}
+
+func (r *Runtime) sandboxBundleDir(id string) string {
+	return filepath.Join(r.rootDir, sandboxesSubdir, id)
+}
+
+func (r *Runtime) containerBundleDir(id string) string {
+	return filepath.Join(r.rootDir, containersSubdir, id)
+}
+
+// stopRuncProcess stops id's init process. If timeout is positive, it sends
+// SIGTERM and waits up to timeout for the process to exit before escalating
+// to SIGKILL; a non-positive timeout sends SIGKILL straight away.
+func stopRuncProcess(id string, timeout time.Duration) error {
+	state, err := getRuncState(id)
+	if err != nil {
+		return err
+	}
+	if state.Status == runcStatusStopped {
+		return nil
+	}
+
+	if timeout <= 0 {
+		return runcKill(id, "KILL")
+	}
+
+	if err := runcKill(id, "TERM"); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		state, err := getRuncState(id)
+		if err != nil {
+			return err
+		}
+		if state.Status == runcStatusStopped {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return runcKill(id, "KILL")
+}
+
+// toPodSandboxState maps a runc process status to the CRI PodSandboxState.
+func toPodSandboxState(status runcStatus) kubeapi.PodSandboxState {
+	if status == runcStatusRunning || status == runcStatusCreated {
+		return kubeapi.PodSandboxState_SANDBOX_READY
+	}
+
+	return kubeapi.PodSandboxState_SANDBOX_NOTREADY
+}
+
+func inMap(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
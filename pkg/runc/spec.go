@@ -0,0 +1,254 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// ociSpec is the subset of the OCI runtime spec (bundle/config.json) this
+// backend needs to set. It's handwritten rather than imported from
+// opencontainers/runtime-spec so pkg/runc has no dependency beyond the runc
+// binary itself.
+type ociSpec struct {
+	OCIVersion string     `json:"ociVersion"`
+	Process    ociProcess `json:"process"`
+	Root       ociRoot    `json:"root"`
+	Hostname   string     `json:"hostname,omitempty"`
+	Mounts     []ociMount `json:"mounts,omitempty"`
+	Linux      ociLinux   `json:"linux"`
+}
+
+type ociProcess struct {
+	Terminal bool     `json:"terminal"`
+	Cwd      string   `json:"cwd"`
+	Env      []string `json:"env,omitempty"`
+	Args     []string `json:"args"`
+}
+
+type ociRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type ociMount struct {
+	Destination string   `json:"destination"`
+	Source      string   `json:"source,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type ociLinux struct {
+	Namespaces []ociNamespace `json:"namespaces"`
+	Resources  *ociResources  `json:"resources,omitempty"`
+}
+
+type ociNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type ociResources struct {
+	CPU    *ociCPU    `json:"cpu,omitempty"`
+	Memory *ociMemory `json:"memory,omitempty"`
+}
+
+type ociCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+type ociMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// buildSandboxSpec builds the OCI spec for a sandbox's pause process. The
+// sandbox shares the host's root filesystem read-only, since this backend
+// has no image store of its own to pull a dedicated pause image into; it
+// only needs a long-running process to own the sandbox's PID/IPC/UTS (and,
+// unless hostNetwork, network) namespaces for containers to join.
+// netNSPath is the CNI-created namespace from network.Plugin.SetUpPod, or
+// empty for a hostNetwork sandbox.
+func buildSandboxSpec(config *kubeapi.PodSandboxConfig, netNSPath string) *ociSpec {
+	hostname := config.GetHostname()
+	if hostname == "" {
+		hostname = config.GetMetadata().GetName()
+	}
+
+	namespaces := []ociNamespace{
+		{Type: "pid"},
+		{Type: "ipc"},
+		{Type: "uts"},
+		{Type: "mount"},
+	}
+	if netNSPath != "" {
+		namespaces = append(namespaces, ociNamespace{Type: "network", Path: netNSPath})
+	}
+
+	return &ociSpec{
+		OCIVersion: ociVersion,
+		Hostname:   hostname,
+		Process: ociProcess{
+			Cwd:  "/",
+			Args: []string{"/bin/sleep", "infinity"},
+		},
+		Root: ociRoot{
+			Path:     "/",
+			Readonly: true,
+		},
+		Mounts: defaultMounts(),
+		Linux: ociLinux{
+			Namespaces: namespaces,
+		},
+	}
+}
+
+// buildContainerSpec builds the OCI spec for a container, joining the
+// PID/IPC/UTS/network namespaces of its sandbox's pause process (identified
+// by sandboxPid) while keeping its own mount namespace and rootfs.
+func buildContainerSpec(config *kubeapi.ContainerConfig, rootfs string, sandboxPid int) (*ociSpec, error) {
+	args := append(append([]string{}, config.GetCommand()...), config.GetArgs()...)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("container config for %q has no command", config.GetMetadata().GetName())
+	}
+
+	cwd := config.GetWorkingDir()
+	if cwd == "" {
+		cwd = "/"
+	}
+
+	env := make([]string, 0, len(config.GetEnvs()))
+	for _, kv := range config.GetEnvs() {
+		env = append(env, fmt.Sprintf("%s=%s", kv.GetKey(), kv.GetValue()))
+	}
+
+	nsPath := func(nsType string) string {
+		return fmt.Sprintf("/proc/%d/ns/%s", sandboxPid, nsType)
+	}
+
+	mounts := append(defaultMounts(), toOCIMounts(config.GetMounts())...)
+
+	return &ociSpec{
+		OCIVersion: ociVersion,
+		Process: ociProcess{
+			Terminal: config.GetTty(),
+			Cwd:      cwd,
+			Env:      env,
+			Args:     args,
+		},
+		Root: ociRoot{
+			Path:     rootfs,
+			Readonly: config.GetLinux().GetSecurityContext().GetReadonlyRootfs(),
+		},
+		Mounts: mounts,
+		Linux: ociLinux{
+			Namespaces: []ociNamespace{
+				{Type: "pid", Path: nsPath("pid")},
+				{Type: "ipc", Path: nsPath("ipc")},
+				{Type: "uts", Path: nsPath("uts")},
+				{Type: "network", Path: nsPath("net")},
+				{Type: "mount"},
+			},
+			Resources: toOCIResources(config.GetLinux().GetResources()),
+		},
+	}, nil
+}
+
+// defaultMounts are the mounts every bundle needs regardless of what the CRI
+// config asked for, matching what `runc spec` generates by default.
+func defaultMounts() []ociMount {
+	return []ociMount{
+		{Destination: "/proc", Type: "proc", Source: "proc"},
+		{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+		{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+		{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+	}
+}
+
+// toOCIMounts translates CRI bind mount requests into OCI bind mounts.
+func toOCIMounts(mounts []*kubeapi.Mount) []ociMount {
+	ociMounts := make([]ociMount, 0, len(mounts))
+	for _, m := range mounts {
+		options := []string{"bind", "rw"}
+		if m.GetReadonly() {
+			options = []string{"bind", "ro"}
+		}
+
+		ociMounts = append(ociMounts, ociMount{
+			Destination: m.GetContainerPath(),
+			Source:      m.GetHostPath(),
+			Type:        "bind",
+			Options:     options,
+		})
+	}
+
+	return ociMounts
+}
+
+// toOCIResources translates CRI resource limits into OCI cgroup resources,
+// leaving fields unset (and letting runc/the kernel default them) when the
+// CRI config didn't ask for a limit.
+func toOCIResources(resources *kubeapi.LinuxContainerResources) *ociResources {
+	if resources == nil {
+		return nil
+	}
+
+	var cpu *ociCPU
+	if shares, quota, period := resources.GetCpuShares(), resources.GetCpuQuota(), resources.GetCpuPeriod(); shares > 0 || quota > 0 || period > 0 {
+		cpu = &ociCPU{}
+		if shares > 0 {
+			v := uint64(shares)
+			cpu.Shares = &v
+		}
+		if quota > 0 {
+			cpu.Quota = &quota
+		}
+		if period > 0 {
+			v := uint64(period)
+			cpu.Period = &v
+		}
+	}
+
+	var memory *ociMemory
+	if limit := resources.GetMemoryLimitInBytes(); limit > 0 {
+		memory = &ociMemory{Limit: &limit}
+	}
+
+	if cpu == nil && memory == nil {
+		return nil
+	}
+
+	return &ociResources{CPU: cpu, Memory: memory}
+}
+
+// writeSpec marshals spec as bundleDir/config.json, the file `runc run`
+// reads to create the sandbox/container.
+func writeSpec(bundleDir string, spec *ociSpec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(bundleDir, "config.json"), data, 0640)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// runcStatus is one of the process states `runc state` reports.
+type runcStatus string
+
+const (
+	runcStatusCreated runcStatus = "created"
+	runcStatusRunning runcStatus = "running"
+	runcStatusStopped runcStatus = "stopped"
+	runcStatusPaused  runcStatus = "paused"
+)
+
+// runcState is the subset of `runc state`'s JSON output this backend needs.
+type runcState struct {
+	ID     string     `json:"id"`
+	Pid    int        `json:"pid"`
+	Status runcStatus `json:"status"`
+	Bundle string     `json:"bundle"`
+}
+
+// runcRun creates and starts id, detached, from the OCI bundle at
+// bundleDir.
+func runcRun(id, bundleDir string) error {
+	return execRunc("run", "-d", "--bundle", bundleDir, id)
+}
+
+// getRuncState returns id's current state. A non-existent id is reported
+// through the returned error, since `runc state` exits non-zero for it.
+func getRuncState(id string) (*runcState, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("runc", "state", id)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("runc state %q failed: %v", id, err)
+	}
+
+	state := &runcState{}
+	if err := json.Unmarshal(out.Bytes(), state); err != nil {
+		return nil, fmt.Errorf("parse runc state output for %q failed: %v", id, err)
+	}
+
+	return state, nil
+}
+
+// runcKill sends signal to id's init process.
+func runcKill(id, signal string) error {
+	return execRunc("kill", id, signal)
+}
+
+// runcDelete removes id's runc state. force also kills it first if still
+// running, matching `runc delete --force`.
+func runcDelete(id string, force bool) error {
+	args := []string{"delete"}
+	if force {
+		args = append(args, "--force")
+	}
+
+	return execRunc(append(args, id)...)
+}
+
+// execRunc runs the runc CLI with args and returns its combined output as
+// part of the error if it fails.
+func execRunc(args ...string) error {
+	_, err := execRuncOutput(args...)
+	return err
+}
+
+// execRuncOutput runs the runc CLI with args and returns its combined
+// output, wrapping the output into the error if the command fails.
+func execRuncOutput(args ...string) (string, error) {
+	out, err := exec.Command("runc", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("runc %v failed: %v: %s", args, err, out)
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/golang/glog"
+
+	kubeapi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+
+	"k8s.io/frakti/pkg/hyper/stats"
+)
+
+// statsTimeout bounds how long `runc events --stats` may take to report a
+// single sample before this backend gives up on it.
+const statsTimeout = 5 * time.Second
+
+// runcEventStats is the subset of `runc events --stats <id>`'s one-shot
+// JSON output this backend needs.
+type runcEventStats struct {
+	Data struct {
+		CPU struct {
+			Usage struct {
+				Total uint64 `json:"total"`
+			} `json:"usage"`
+		} `json:"cpu"`
+		Memory struct {
+			Usage struct {
+				Usage uint64 `json:"usage"`
+			} `json:"usage"`
+		} `json:"memory"`
+	} `json:"data"`
+}
+
+// getRuncStats reads a single cgroup stats sample for id via `runc events
+// --stats`.
+func getRuncStats(id string) (*runcEventStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "runc", "events", "--stats", id)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("runc events --stats %q failed: %v", id, err)
+	}
+
+	eventStats := &runcEventStats{}
+	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), eventStats); err != nil {
+		return nil, fmt.Errorf("parse runc stats output for %q failed: %v", id, err)
+	}
+
+	return eventStats, nil
+}
+
+// ContainerStats returns a snapshot of containerID's resource usage.
+func (r *Runtime) ContainerStats(containerID string) (*kubeapi.ContainerStats, error) {
+	checkpoint, err := r.checkpoints.ReadContainer(containerID)
+	if err != nil {
+		glog.Errorf("Read checkpoint for container %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	eventStats, err := getRuncStats(containerID)
+	if err != nil {
+		glog.Errorf("Get stats for container %q failed: %v", containerID, err)
+		return nil, err
+	}
+
+	now := time.Now()
+	r.statsCache.Add(containerID, stats.Sample{
+		Timestamp:        now,
+		CPUUsageNanos:    eventStats.Data.CPU.Usage.Total,
+		MemoryWorkingSet: eventStats.Data.Memory.Usage.Usage,
+	})
+
+	return r.buildContainerStats(containerID, checkpoint.Config.GetMetadata(), eventStats, now), nil
+}
+
+// ListContainerStats returns a snapshot of resource usage for every
+// container matching filter.
+func (r *Runtime) ListContainerStats(filter *kubeapi.ContainerStatsFilter) ([]*kubeapi.ContainerStats, error) {
+	ids, err := r.checkpoints.ListContainerIDs()
+	if err != nil {
+		glog.Errorf("List checkpointed containers failed: %v", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	items := make([]*kubeapi.ContainerStats, 0, len(ids))
+	for _, id := range ids {
+		checkpoint, err := r.checkpoints.ReadContainer(id)
+		if err != nil {
+			glog.Warningf("Read checkpoint for container %q failed, skipping: %v", id, err)
+			continue
+		}
+
+		if filter != nil {
+			if filter.Id != nil && id != filter.GetId() {
+				continue
+			}
+			if filter.PodSandboxId != nil && checkpoint.PodSandboxID != filter.GetPodSandboxId() {
+				continue
+			}
+		}
+
+		eventStats, err := getRuncStats(id)
+		if err != nil {
+			glog.Warningf("Get stats for container %q failed, skipping: %v", id, err)
+			continue
+		}
+		r.statsCache.Add(id, stats.Sample{
+			Timestamp:        now,
+			CPUUsageNanos:    eventStats.Data.CPU.Usage.Total,
+			MemoryWorkingSet: eventStats.Data.Memory.Usage.Usage,
+		})
+
+		items = append(items, r.buildContainerStats(id, checkpoint.Config.GetMetadata(), eventStats, now))
+	}
+
+	return items, nil
+}
+
+// buildContainerStats assembles the CRI ContainerStats proto for
+// containerID from a fresh cgroup sample, filling in the CPU usage rate
+// from the stats cache when at least two samples are available.
+func (r *Runtime) buildContainerStats(containerID string, metadata *kubeapi.ContainerMetadata, eventStats *runcEventStats, now time.Time) *kubeapi.ContainerStats {
+	nowNano := now.UnixNano()
+
+	cpuUsage := eventStats.Data.CPU.Usage.Total
+	cpu := &kubeapi.CpuUsage{
+		Timestamp:            &nowNano,
+		UsageCoreNanoSeconds: &kubeapi.UInt64Value{Value: &cpuUsage},
+	}
+	if rate, ok := r.statsCache.CPUNanoCores(containerID); ok {
+		cpu.UsageNanoCores = &kubeapi.UInt64Value{Value: &rate}
+	}
+
+	memoryUsage := eventStats.Data.Memory.Usage.Usage
+	memory := &kubeapi.MemoryUsage{
+		Timestamp:       &nowNano,
+		WorkingSetBytes: &kubeapi.UInt64Value{Value: &memoryUsage},
+	}
+
+	return &kubeapi.ContainerStats{
+		Attributes: &kubeapi.ContainerAttributes{
+			Id:       &containerID,
+			Metadata: metadata,
+		},
+		Cpu:    cpu,
+		Memory: memory,
+	}
+}